@@ -1,27 +1,28 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/indieinfra/scribble/config"
 	"github.com/indieinfra/scribble/server"
+	"github.com/indieinfra/scribble/server/util"
 )
 
 func main() {
-	log.SetPrefix("scribble: ")
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile | log.Lmsgprefix)
-
-	log.Println("loading configuration...")
+	slog.Info("loading configuration...")
 	configFile := os.Getenv("CONFIG_FILE")
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
-		log.Fatalf("failed to load configuration: %v", err)
-		return
+		slog.Error("failed to load configuration", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("starting server...")
+	slog.SetDefault(util.NewLogger(cfg, os.Stderr))
+
+	slog.Info("starting server...")
 	if err := server.StartServer(cfg); err != nil {
-		log.Fatalf("server exited with error: %v", err)
+		slog.Error("server exited with error", "error", err)
+		os.Exit(1)
 	}
 }
@@ -2,6 +2,10 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
@@ -13,11 +17,74 @@ func (c *Config) Validate() error {
 	validate.RegisterValidation("localpath", ValidateLocalpath)
 	validate.RegisterValidation("identifier", ValidateIdentifier)
 	validate.RegisterValidation("pathpattern", ValidatePathPattern)
+	validate.RegisterValidation("scope", ValidateScope)
 
 	if err := validate.Struct(c); err != nil {
 		return err
 	}
 
+	if c.Server.Cors.AllowCredentials && slices.Contains(c.Server.Cors.AllowedOrigins, "*") {
+		return errors.New(`server.cors.allow_credentials cannot be combined with allowed_origins "*"; list the origins allowed to send credentials`)
+	}
+
+	seen := make(map[string]bool)
+	for _, site := range c.AllSites() {
+		me := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(site.Micropub.MeUrl), "/"))
+		if seen[me] {
+			return fmt.Errorf("more than one site is configured for me_url %q", site.Micropub.MeUrl)
+		}
+		seen[me] = true
+
+		if err := site.Micropub.validateTokenVerification(); err != nil {
+			return fmt.Errorf("site %q: %w", site.Micropub.MeUrl, err)
+		}
+
+		if err := site.Hooks.Build.validate(); err != nil {
+			return fmt.Errorf("site %q: %w", site.Micropub.MeUrl, err)
+		}
+	}
+
+	return nil
+}
+
+// AllSites returns every site hosted by this instance. The site defined at the top level of the
+// configuration always comes first, followed by any additional sites in the order they were declared.
+func (c *Config) AllSites() []Site {
+	primary := Site{Micropub: c.Micropub, Content: c.Content, Media: c.Media, Hooks: c.Hooks, Enrich: c.Enrich}
+	return append([]Site{primary}, c.Sites...)
+}
+
+func (m *Micropub) validateTokenVerification() error {
+	tv := &m.TokenVerification
+	if tv.Mode != "jwt" {
+		if m.TokenEndpoint == "" {
+			return errors.New("token_endpoint is required unless token_verification.mode is jwt")
+		}
+		return nil
+	}
+
+	if (tv.JwksFile == "") == (tv.JwksUrl == "") {
+		return errors.New("exactly one of token_verification.jwks_file or token_verification.jwks_url must be set")
+	}
+
+	if tv.FallbackRemote && m.TokenEndpoint == "" {
+		return errors.New("token_endpoint is required when token_verification.fallback_remote is enabled")
+	}
+
+	return nil
+}
+
+// validate catches a command written as a single shell string, which would otherwise be looked up as
+// one executable named after the whole line.
+func (b *BuildHook) validate() error {
+	if len(b.Command) == 1 && strings.ContainsAny(b.Command[0], " \t") {
+		var args []string
+		for _, arg := range strings.Fields(b.Command[0]) {
+			args = append(args, strconv.Quote(arg))
+		}
+		return fmt.Errorf("hooks.build.command is run without a shell and must be an argument list, e.g. [%s]", strings.Join(args, ", "))
+	}
+
 	return nil
 }
 
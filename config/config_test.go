@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildHookValidate(t *testing.T) {
+	tests := []struct {
+		command []string
+		wantErr string
+	}{
+		{nil, ""},
+		{[]string{"make"}, ""},
+		{[]string{"hugo", "--minify"}, ""},
+		{[]string{"sh", "-c", "hugo --minify && rsync -a public/ /srv/www/"}, ""},
+		{[]string{"hugo --minify"}, `e.g. ["hugo", "--minify"]`},
+	}
+
+	for _, tt := range tests {
+		err := (&BuildHook{Command: tt.command}).validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", tt.command, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: err = %v, want it to mention %s", tt.command, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateRejectsCredentialedWildcardCors(t *testing.T) {
+	tests := []struct {
+		cors    Cors
+		wantErr bool
+	}{
+		{Cors{AllowedOrigins: []string{"*"}}, false},
+		{Cors{AllowedOrigins: []string{"https://quill.p3k.io"}, AllowCredentials: true}, false},
+		{Cors{AllowedOrigins: []string{"https://quill.p3k.io", "*"}, AllowCredentials: true}, true},
+	}
+
+	for _, tt := range tests {
+		cfg := loadDefault(t)
+		cfg.Server.Cors = tt.cors
+
+		err := cfg.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: err = %v, want error: %v", tt.cors, err, tt.wantErr)
+		}
+	}
+}
+
+// loadDefault loads the documented default configuration, which must itself be valid.
+func loadDefault(t *testing.T) *Config {
+	t.Helper()
+
+	cfg, err := LoadConfig("../config.default.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
@@ -1,11 +1,36 @@
 package config
 
+import "time"
+
 type Config struct {
 	Debug    bool     `mapstructure:"debug"`
+	Logging  Logging  `mapstructure:"logging"`
+	Tracing  Tracing  `mapstructure:"tracing"`
 	Server   Server   `mapstructure:"server"`
 	Micropub Micropub `mapstructure:"micropub"`
 	Content  Content  `mapstructure:"content"`
 	Media    Media    `mapstructure:"media"`
+	Hooks    Hooks    `mapstructure:"hooks"`
+	Enrich   Enrich   `mapstructure:"enrich"`
+	Sites    []Site   `mapstructure:"sites" validate:"dive"`
+}
+
+// Logging selects the log level (debug, info, warn or error; default info) and output format (text or
+// json; default text). The top-level debug flag, when set, forces the debug level.
+type Logging struct {
+	Level  string `mapstructure:"level" validate:"omitempty,oneof=debug info warn error"`
+	Format string `mapstructure:"format" validate:"omitempty,oneof=text json"`
+}
+
+// Tracing configures OpenTelemetry-compatible request tracing. The otlp exporter posts spans to an
+// OTLP/HTTP collector at endpoint; stdout writes them as JSON lines for local testing.
+type Tracing struct {
+	Enabled     bool              `mapstructure:"enabled"`
+	Exporter    string            `mapstructure:"exporter" validate:"required_if=Enabled true,omitempty,oneof=otlp stdout"`
+	Endpoint    string            `mapstructure:"endpoint" validate:"required_if=Exporter otlp,omitempty,url"`
+	Headers     map[string]string `mapstructure:"headers"`
+	ServiceName string            `mapstructure:"service_name"`
+	SampleRatio float64           `mapstructure:"sample_ratio" validate:"min=0,max=1"`
 }
 
 type Server struct {
@@ -13,17 +38,189 @@ type Server struct {
 	Port      int          `mapstructure:"port" validate:"required,min=1,max=65535"`
 	PublicUrl string       `mapstructure:"public_url" validate:"required,url"`
 	Limits    ServerLimits `mapstructure:"limits"`
+	RateLimit RateLimit    `mapstructure:"rate_limit"`
+	Cors      Cors         `mapstructure:"cors"`
+	Metrics   Metrics      `mapstructure:"metrics"`
+	// DataDir holds state Scribble persists locally, such as pending webhook deliveries (default "data").
+	DataDir string `mapstructure:"data_dir"`
+}
+
+// Metrics controls the Prometheus metrics endpoint served on /metrics.
+type Metrics struct {
+	Enabled bool `mapstructure:"enabled"`
 }
 
 type ServerLimits struct {
 	MaxPayloadSize  uint `mapstructure:"max_payload_size" validate:"required"`
 	MaxFileSize     uint `mapstructure:"max_file_size" validate:"required"`
 	MaxMultipartMem uint `mapstructure:"max_multipart_mem" validate:"required"`
+	// MaxFiles bounds how many files a multipart Micropub request may carry (default 10).
+	MaxFiles uint `mapstructure:"max_files"`
+	// MaxFileSizes overrides MaxFileSize for particular media types, keyed by type or wildcard such
+	// as "video/*". Limits may be larger or smaller than MaxFileSize.
+	MaxFileSizes map[string]uint `mapstructure:"max_file_sizes"`
+}
+
+// Cors configures cross-origin access for browser-based clients. Origins are matched exactly; "*"
+// allows any origin, and cannot be combined with AllowCredentials. CORS headers are only sent when
+// AllowedOrigins is non-empty.
+type Cors struct {
+	AllowedOrigins   []string      `mapstructure:"allowed_origins" validate:"dive,url|eq=*"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+// RateLimit configures token-bucket budgets. Requests applies to every request per remote IP; Create,
+// Update (which also covers delete and undelete) and Media apply per token, client_id and remote IP.
+type RateLimit struct {
+	Enabled        bool       `mapstructure:"enabled"`
+	TrustedProxies []string   `mapstructure:"trusted_proxies" validate:"dive,cidr|ip"`
+	Requests       RateBudget `mapstructure:"requests"`
+	Create         RateBudget `mapstructure:"create"`
+	Update         RateBudget `mapstructure:"update"`
+	Media          RateBudget `mapstructure:"media"`
+}
+
+// RateBudget allows Limit requests every Per (default one minute), with bursts of up to Burst requests
+// (default Limit). A zero Limit disables the budget.
+type RateBudget struct {
+	Limit int           `mapstructure:"limit" validate:"min=0"`
+	Per   time.Duration `mapstructure:"per"`
+	Burst int           `mapstructure:"burst" validate:"min=0"`
+}
+
+// Site describes an additional site hosted by this instance. Requests are routed to a site by
+// the "me" value of the access token they present.
+type Site struct {
+	Micropub Micropub `mapstructure:"micropub"`
+	Content  Content  `mapstructure:"content"`
+	Media    Media    `mapstructure:"media"`
+	Hooks    Hooks    `mapstructure:"hooks"`
+	Enrich   Enrich   `mapstructure:"enrich"`
+}
+
+// Enrich configures fetching the pages a new post refers to. Fetches time out after Timeout (default
+// 5s) and read at most MaxBytes (default 1 MiB). DenyDomains and, when non-empty, AllowDomains limit
+// which hosts (and their subdomains) are fetched; AllowPrivate permits loopback and private networks.
+type Enrich struct {
+	ReplyContext ReplyContext  `mapstructure:"reply_context"`
+	LinkPreview  LinkPreview   `mapstructure:"link_preview"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	MaxBytes     int64         `mapstructure:"max_bytes" validate:"min=0"`
+	AllowDomains []string      `mapstructure:"allow_domains" validate:"dive,hostname"`
+	DenyDomains  []string      `mapstructure:"deny_domains" validate:"dive,hostname"`
+	AllowPrivate bool          `mapstructure:"allow_private"`
+}
+
+// ReplyContext replaces bare URLs in Properties (default in-reply-to, like-of and repost-of) with an
+// h-cite describing the page they point to.
+type ReplyContext struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	Properties []string `mapstructure:"properties" validate:"dive,oneof=in-reply-to like-of repost-of bookmark-of"`
+}
+
+// LinkPreview replaces bare URLs in Properties (default bookmark-of) with an h-cite built from the
+// page's OpenGraph and Twitter card metadata. With ArchiveImage, the preview image (at most
+// MaxImageBytes, default 5 MiB) is copied into the media store.
+type LinkPreview struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Properties    []string `mapstructure:"properties" validate:"dive,oneof=in-reply-to like-of repost-of bookmark-of"`
+	ArchiveImage  bool     `mapstructure:"archive_image"`
+	MaxImageBytes int64    `mapstructure:"max_image_bytes" validate:"min=0"`
+}
+
+// Hooks configures what happens after content on a site changes.
+type Hooks struct {
+	Webhooks   []Webhook  `mapstructure:"webhooks" validate:"dive"`
+	Build      BuildHook  `mapstructure:"build"`
+	Webmention Webmention `mapstructure:"webmention"`
+	WebSub     WebSub     `mapstructure:"websub"`
+}
+
+// WebSub pings Hub with hub.mode=publish for each of Topics (default the site's me URL) after posts
+// change, so subscribed feed readers fetch the update. Failed pings are retried up to MaxAttempts
+// times (default 5).
+type WebSub struct {
+	Hub         string        `mapstructure:"hub" validate:"omitempty,url"`
+	Topics      []string      `mapstructure:"topics" validate:"dive,url"`
+	MaxAttempts int           `mapstructure:"max_attempts" validate:"min=0"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+// Webmention sends Webmentions to the URLs a post replies to, likes, reposts or bookmarks and to the
+// links in its content, after it is created, updated or deleted. Failed deliveries are retried up to
+// MaxAttempts times (default 3). AllowPrivate permits targets on loopback and private networks.
+type Webmention struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	MaxAttempts  int           `mapstructure:"max_attempts" validate:"min=0"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	AllowPrivate bool          `mapstructure:"allow_private"`
+}
+
+// BuildHook runs Command in Dir after content changes, once no further events have arrived for
+// Debounce (default 5s). Command is an argv list executed directly, without a shell. Builds running
+// longer than Timeout (default 10m) are killed. Events, when non-empty, limits the event types that
+// trigger a build.
+type BuildHook struct {
+	Command  []string      `mapstructure:"command" validate:"omitempty,dive,required"`
+	Dir      string        `mapstructure:"dir"`
+	Events   []string      `mapstructure:"events" validate:"dive,oneof=post.created post.updated post.deleted post.undeleted media.uploaded media.deleted"`
+	Debounce time.Duration `mapstructure:"debounce"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+// Webhook receives a signed JSON payload for each content lifecycle event. Events, when non-empty,
+// limits the event types delivered. Failed deliveries are retried with exponential backoff up to
+// MaxAttempts times (default 8).
+type Webhook struct {
+	Url         string   `mapstructure:"url" validate:"required,url"`
+	Secret      string   `mapstructure:"secret" validate:"required"`
+	Events      []string `mapstructure:"events" validate:"dive,oneof=post.created post.updated post.deleted post.undeleted media.uploaded media.deleted"`
+	MaxAttempts int      `mapstructure:"max_attempts" validate:"min=0"`
 }
 
 type Micropub struct {
-	MeUrl         string `mapstructure:"me_url" validate:"required,url"`
-	TokenEndpoint string `mapstructure:"token_endpoint" validate:"required,url"`
+	MeUrl string `mapstructure:"me_url" validate:"required,url"`
+	// PublicUrl is where the site's Micropub and media endpoints are served, when it has a host of its
+	// own (default: the server's public_url). Only needed when sites verify tokens at different token
+	// endpoints: a token that does not name its site is then only sent to the endpoint of the site
+	// served at the request's host.
+	PublicUrl         string            `mapstructure:"public_url" validate:"omitempty,url"`
+	TokenEndpoint     string            `mapstructure:"token_endpoint" validate:"omitempty,url"`
+	TokenVerification TokenVerification `mapstructure:"token_verification"`
+	ScopePolicy       ScopePolicy       `mapstructure:"scope_policy"`
+}
+
+// TokenVerification selects how access tokens are verified. In "remote" mode (the default) every token
+// is sent to the token endpoint. In "jwt" mode tokens are verified locally against a JWKS, and the token
+// endpoint is only consulted when FallbackRemote is set, and then never for JWTs from Issuer.
+type TokenVerification struct {
+	Mode           string        `mapstructure:"mode" validate:"omitempty,oneof=remote jwt"`
+	JwksFile       string        `mapstructure:"jwks_file"`
+	JwksUrl        string        `mapstructure:"jwks_url" validate:"omitempty,url"`
+	JwksRefresh    time.Duration `mapstructure:"jwks_refresh"`
+	Issuer         string        `mapstructure:"issuer" validate:"required_if=Mode jwt"`
+	Audience       string        `mapstructure:"audience"`
+	ClockSkew      time.Duration `mapstructure:"clock_skew"`
+	FallbackRemote bool          `mapstructure:"fallback_remote"`
+}
+
+// ScopePolicy refines how token scopes are interpreted. Implies maps a scope to the scopes it grants in
+// addition to itself; when unset, create implies draft and media. Clients restricts individual clients.
+type ScopePolicy struct {
+	Implies map[string][]string `mapstructure:"implies" validate:"dive,keys,scope,endkeys,dive,scope"`
+	Clients []ClientPolicy      `mapstructure:"clients" validate:"dive"`
+}
+
+// ClientPolicy restricts what a single client (identified by client_id) may do. Allow, when non-empty,
+// lists the only scopes the client may use; Deny lists scopes it may never use. PostTypes and Channels,
+// when non-empty, limit the kinds of posts it may create and the channels it may post to.
+type ClientPolicy struct {
+	ClientId  string   `mapstructure:"client_id" validate:"required,url"`
+	Allow     []string `mapstructure:"allow" validate:"dive,scope"`
+	Deny      []string `mapstructure:"deny" validate:"dive,scope"`
+	PostTypes []string `mapstructure:"post_types" validate:"dive,oneof=note article photo video audio reply repost like bookmark rsvp checkin event"`
+	Channels  []string `mapstructure:"channels"`
 }
 
 type Content struct {
@@ -52,6 +249,67 @@ type Media struct {
 	PublicBaseUrl    string           `mapstructure:"public_base_url" validate:"required,url"`
 	MediaPathPattern string           `mapstructure:"media_path_pattern" validate:"required,pathpattern"`
 	S3               *S3MediaStrategy `mapstructure:"s3" validate:"required_if=Strategy s3"`
+	Images           Images           `mapstructure:"images"`
+	// AllowedTypes lists the media types, or wildcards such as "image/*", accepted for upload. Types
+	// are sniffed from the file contents; a built-in list of common image, video and audio formats
+	// applies when empty.
+	AllowedTypes []string `mapstructure:"allowed_types"`
+	// KeepMetadata disables removing EXIF, XMP and IPTC metadata from uploaded JPEG, PNG and WebP files.
+	KeepMetadata bool          `mapstructure:"keep_metadata"`
+	GC           MediaGC       `mapstructure:"gc"`
+	Resumable    Resumable     `mapstructure:"resumable"`
+	Analysis     MediaAnalysis `mapstructure:"analysis"`
+}
+
+// MediaAnalysis configures reading the duration, codecs and dimensions of uploaded audio and video
+// with ffprobe, and capturing video poster frames with ffmpeg when Posters is set. Binaries are found
+// on PATH unless given as paths.
+type MediaAnalysis struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Ffprobe string        `mapstructure:"ffprobe"`
+	Ffmpeg  string        `mapstructure:"ffmpeg"`
+	Posters bool          `mapstructure:"posters"`
+	Timeout time.Duration `mapstructure:"timeout" validate:"min=0"`
+}
+
+// Resumable configures tus resumable uploads on /media/uploads. Chunks are staged under the data
+// directory until the upload completes; uploads not completed within Expiry (default 24h) are
+// discarded.
+type Resumable struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Expiry  time.Duration `mapstructure:"expiry" validate:"min=0"`
+}
+
+// MediaGC configures the removal of uploaded media that no post refers to. Collection runs every
+// Interval (never when zero) and on demand through the media endpoint; files younger than
+// GracePeriod (default 24h) are kept so posts still being written can claim them.
+type MediaGC struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	Interval    time.Duration `mapstructure:"interval" validate:"min=0"`
+	GracePeriod time.Duration `mapstructure:"grace_period" validate:"min=0"`
+	DryRun      bool          `mapstructure:"dry_run"`
+}
+
+// Images configures the processing of uploaded images. Each variant narrower than the original is
+// generated in every listed format ("original" keeps the source format) and stored beside it.
+type Images struct {
+	Enabled   bool           `mapstructure:"enabled"`
+	Variants  []ImageVariant `mapstructure:"variants" validate:"required_if=Enabled true,dive"`
+	Formats   []string       `mapstructure:"formats" validate:"dive,oneof=original jpeg png webp avif"`
+	Quality   int            `mapstructure:"quality" validate:"min=0,max=100"`
+	MaxPixels int            `mapstructure:"max_pixels" validate:"min=0"`
+	Encoders  ImageEncoders  `mapstructure:"encoders"`
+}
+
+type ImageVariant struct {
+	Name  string `mapstructure:"name" validate:"required,alphanum"`
+	Width int    `mapstructure:"width" validate:"required,min=1"`
+}
+
+// ImageEncoders names the external commands used for formats the standard library cannot write.
+type ImageEncoders struct {
+	Webp string `mapstructure:"webp"`
+	Avif string `mapstructure:"avif"`
 }
 
 type S3MediaStrategy struct {
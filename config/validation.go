@@ -33,6 +33,16 @@ func ValidateIdentifier(fl validator.FieldLevel) bool {
 	return matched
 }
 
+// ValidateScope checks that the field names one of the Micropub scopes understood by Scribble.
+func ValidateScope(fl validator.FieldLevel) bool {
+	switch fl.Field().String() {
+	case "read", "create", "draft", "update", "delete", "undelete", "media":
+		return true
+	}
+
+	return false
+}
+
 func ValidatePathPattern(fl validator.FieldLevel) bool {
 	s := fl.Field().String()
 	if s == "" {
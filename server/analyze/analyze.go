@@ -0,0 +1,179 @@
+package analyze
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// ErrUnavailable is returned by NewFFmpeg when the configured ffprobe binary cannot be found.
+var ErrUnavailable = errors.New("media analyzer not available")
+
+// Info describes an uploaded audio or video file.
+type Info struct {
+	// Duration is the length of the media in seconds.
+	Duration   float64
+	VideoCodec string
+	AudioCodec string
+	// Width and Height are the displayed dimensions of a video, after any rotation.
+	Width  int
+	Height int
+	// Poster is a JPEG frame from a video, if one was captured.
+	Poster []byte
+}
+
+// Analyzer extracts details from audio and video files.
+type Analyzer interface {
+	// Analyze inspects the file at path, of the given media type.
+	Analyze(ctx context.Context, path string, contentType string) (*Info, error)
+}
+
+// FFmpeg analyzes media with the ffprobe and ffmpeg command-line tools.
+type FFmpeg struct {
+	ffprobe string
+	ffmpeg  string
+	timeout time.Duration
+}
+
+// NewFFmpeg returns an analyzer using the configured binaries, which are looked up on PATH when not
+// given as paths. Posters are captured only if ffmpeg is also available.
+func NewFFmpeg(cfg *config.MediaAnalysis) (*FFmpeg, error) {
+	ffprobe, err := exec.LookPath(cmp.Or(cfg.Ffprobe, "ffprobe"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	a := &FFmpeg{ffprobe: ffprobe, timeout: cfg.Timeout}
+	if a.timeout == 0 {
+		a.timeout = defaultTimeout
+	}
+
+	if cfg.Posters {
+		ffmpeg, err := exec.LookPath(cmp.Or(cfg.Ffmpeg, "ffmpeg"))
+		if err != nil {
+			slog.Warn("ffmpeg not found, video posters disabled", "error", err)
+		} else {
+			a.ffmpeg = ffmpeg
+		}
+	}
+
+	return a, nil
+}
+
+// Analyze runs ffprobe on the file and, for videos, captures a poster. Details already read are
+// returned even if capturing the poster fails.
+func (a *FFmpeg) Analyze(ctx context.Context, path string, contentType string) (*Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, a.ffprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	info, err := parseProbe(output)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.ffmpeg != "" && strings.HasPrefix(contentType, "video/") && info.VideoCodec != "" {
+		poster, err := a.poster(ctx, path, info.Duration)
+		if err != nil {
+			return info, err
+		}
+		info.Poster = poster
+	}
+
+	return info, nil
+}
+
+// poster captures a frame a little way in, since the first is often black, as a JPEG. ffmpeg applies
+// the video's rotation itself.
+func (a *FFmpeg) poster(ctx context.Context, path string, duration float64) ([]byte, error) {
+	at := math.Min(1, duration/2)
+
+	cmd := exec.CommandContext(ctx, a.ffmpeg, "-v", "error", "-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", path,
+		"-frames:v", "1", "-f", "image2pipe", "-c:v", "mjpeg", "-q:v", "3", "pipe:1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if len(output) == 0 {
+		return nil, errors.New("ffmpeg produced no poster frame")
+	}
+
+	return output, nil
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType string            `json:"codec_type"`
+		CodecName string            `json:"codec_name"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Tags      map[string]string `json:"tags"`
+		SideData  []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// parseProbe reads ffprobe's JSON output, taking the first audio and video streams. Cover art
+// embedded in audio files is not counted as video.
+func parseProbe(data []byte) (*Info, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	info := &Info{}
+	if d, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		info.Duration = d
+	}
+
+	for _, s := range probe.Streams {
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic == 0 && info.VideoCodec == "":
+			info.VideoCodec = s.CodecName
+			info.Width, info.Height = s.Width, s.Height
+
+			rotation, _ := strconv.ParseFloat(s.Tags["rotate"], 64)
+			for _, sd := range s.SideData {
+				if sd.Rotation != 0 {
+					rotation = sd.Rotation
+				}
+			}
+			if int(math.Abs(rotation))%180 == 90 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+		case s.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = s.CodecName
+		}
+	}
+
+	return info, nil
+}
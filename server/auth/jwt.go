@@ -0,0 +1,492 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/tracing"
+)
+
+const (
+	defaultJwksRefresh = time.Hour
+	// minJwksRefetch bounds how often an unknown key id may trigger a refetch of the key set.
+	minJwksRefetch = time.Minute
+	// minRsaBits is the smallest RSA modulus accepted for signing keys.
+	minRsaBits = 2048
+)
+
+var (
+	ErrMalformedJWT    = errors.New("malformed jwt")
+	ErrUnsupportedAlg  = errors.New("unsupported jwt algorithm")
+	ErrInvalidSig      = errors.New("invalid jwt signature")
+	ErrUnknownKey      = errors.New("no matching key in key set")
+	ErrInvalidClaims   = errors.New("invalid jwt claims")
+	ErrKeySetFetchFail = errors.New("failed to load key set")
+)
+
+// supportedAlgs are the JWS algorithms access tokens may be signed with.
+var supportedAlgs = []string{"RS256", "ES256", "EdDSA"}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	IssuedAt  int64           `json:"iat"`
+	Me        string          `json:"me"`
+	Scope     string          `json:"scope"`
+	ClientId  string          `json:"client_id"`
+	Nonce     int             `json:"nonce"`
+}
+
+// audiences returns the aud claim as a list, accepting both the string and array forms.
+func (c *jwtClaims) audiences() []string {
+	if len(c.Audience) == 0 {
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(c.Audience, &single); err == nil {
+		return []string{single}
+	}
+
+	var many []string
+	if err := json.Unmarshal(c.Audience, &many); err == nil {
+		return many
+	}
+
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// keySet is a cached JWKS loaded from a file or URL.
+type keySet struct {
+	mu          sync.Mutex
+	source      string
+	isURL       bool
+	refresh     time.Duration
+	keys        []verificationKey
+	loadedAt    time.Time
+	lastAttempt time.Time
+}
+
+var (
+	keySetsMu sync.Mutex
+	keySets   = map[string]*keySet{}
+)
+
+// keySetFor returns the shared key set for the verification config, creating it on first use.
+func keySetFor(tv *config.TokenVerification) *keySet {
+	source, isURL := tv.JwksFile, false
+	if tv.JwksUrl != "" {
+		source, isURL = tv.JwksUrl, true
+	}
+
+	keySetsMu.Lock()
+	defer keySetsMu.Unlock()
+
+	if ks, ok := keySets[source]; ok {
+		return ks
+	}
+
+	refresh := tv.JwksRefresh
+	if refresh <= 0 {
+		refresh = defaultJwksRefresh
+	}
+
+	ks := &keySet{source: source, isURL: isURL, refresh: refresh}
+	keySets[source] = ks
+	return ks
+}
+
+// lookup finds keys usable for the given kid and alg, reloading the set when it is stale or when the
+// kid is unknown (to pick up key rotation).
+func (ks *keySet) lookup(kid string, alg string) ([]verificationKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.keys == nil || time.Since(ks.loadedAt) > ks.refresh {
+		if err := ks.load(); err != nil && ks.keys == nil {
+			return nil, err
+		}
+	}
+
+	matches := ks.match(kid, alg)
+	if len(matches) == 0 && kid != "" && time.Since(ks.lastAttempt) > minJwksRefetch {
+		if err := ks.load(); err != nil {
+			return nil, err
+		}
+		matches = ks.match(kid, alg)
+	}
+
+	if len(matches) == 0 {
+		return nil, ErrUnknownKey
+	}
+
+	return matches, nil
+}
+
+func (ks *keySet) match(kid string, alg string) []verificationKey {
+	var out []verificationKey
+	for _, k := range ks.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+func (ks *keySet) load() error {
+	ks.lastAttempt = time.Now()
+
+	var raw []byte
+	var err error
+	if ks.isURL {
+		raw, err = fetchJwks(ks.source)
+	} else {
+		raw, err = os.ReadFile(ks.source)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKeySetFetchFail, err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("%w: %w", ErrKeySetFetchFail, err)
+	}
+
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pub, err := k.publicKey()
+		if err != nil {
+			// Skip keys we cannot use rather than failing the whole set.
+			continue
+		}
+
+		keys = append(keys, verificationKey{kid: k.Kid, alg: k.Alg, key: pub})
+	}
+
+	ks.keys = keys
+	ks.loadedAt = time.Now()
+	return nil
+}
+
+func fetchJwks(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: tracing.Transport(nil)}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < minRsaBits {
+			return nil, fmt.Errorf("rsa key of %d bits is too short", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// LooksLikeJWT reports whether a token has the three dot-separated segments of a compact JWS.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// claimsIssuer returns the unverified iss claim of a JWT, or "" if it cannot be read. It decides only
+// whether a token is the site's own, never whether it is accepted.
+func claimsIssuer(token string) string {
+	return unverifiedClaims(token).Issuer
+}
+
+// claimsMe returns the unverified me claim of a JWT, or "" if it cannot be read. It decides only which
+// site verifies the token, never whether it is accepted.
+func claimsMe(token string) string {
+	return unverifiedClaims(token).Me
+}
+
+func unverifiedClaims(token string) jwtClaims {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return jwtClaims{}
+	}
+
+	return claims
+}
+
+// verifyJWT verifies a compact JWS access token against the site's key set and checks its claims.
+// An error is returned when the token is not acceptable for the site.
+func verifyJWT(site *config.Micropub, publicUrl string, token string) (*TokenDetails, error) {
+	tv := &site.TokenVerification
+
+	headerPart, rest, _ := strings.Cut(token, ".")
+	payloadPart, sigPart, _ := strings.Cut(rest, ".")
+
+	var header jwtHeader
+	if err := decodeSegment(headerPart, &header); err != nil {
+		return nil, err
+	}
+
+	// Only the asymmetric algorithms are accepted, so "none" and HMAC tokens keyed with a public key
+	// are refused before any key is looked at.
+	if !slices.Contains(supportedAlgs, header.Alg) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlg, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedJWT, err)
+	}
+
+	keys, err := keySetFor(tv).lookup(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(headerPart + "." + payloadPart)
+	verified := false
+	for _, k := range keys {
+		ok, err := verifySignature(header.Alg, k.key, signed, sig)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidSig
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(payloadPart, &claims); err != nil {
+		return nil, err
+	}
+
+	if err := checkClaims(site, publicUrl, &claims); err != nil {
+		return nil, err
+	}
+
+	return &TokenDetails{
+		Me:       claims.Me,
+		ClientId: claims.ClientId,
+		Scope:    claims.Scope,
+		IssuedAt: uint(max(claims.IssuedAt, 0)),
+		Nonce:    claims.Nonce,
+	}, nil
+}
+
+func decodeSegment(segment string, out any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedJWT, err)
+	}
+
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedJWT, err)
+	}
+
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed []byte, sig []byte) (bool, error) {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil, nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false, nil
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest[:], r, s), nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		return ed25519.Verify(pub, signed, sig), nil
+	}
+
+	return false, fmt.Errorf("%w: %q", ErrUnsupportedAlg, alg)
+}
+
+func checkClaims(site *config.Micropub, publicUrl string, claims *jwtClaims) error {
+	tv := &site.TokenVerification
+	now := time.Now()
+
+	if claims.Issuer != tv.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidClaims, claims.Issuer)
+	}
+
+	audience := tv.Audience
+	if audience == "" {
+		audience = publicUrl
+	}
+	if !containsURL(claims.audiences(), audience) {
+		return fmt.Errorf("%w: token audience does not include %q", ErrInvalidClaims, audience)
+	}
+
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing exp", ErrInvalidClaims)
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(tv.ClockSkew)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidClaims)
+	}
+	if claims.NotBefore != nil && now.Add(tv.ClockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return fmt.Errorf("%w: token not yet valid", ErrInvalidClaims)
+	}
+
+	if claims.Me == "" {
+		return fmt.Errorf("%w: missing me", ErrInvalidClaims)
+	}
+	details := TokenDetails{Me: claims.Me}
+	if !details.HasMe(site.MeUrl) {
+		return fmt.Errorf("%w: token belongs to %q", ErrInvalidClaims, claims.Me)
+	}
+
+	if strings.TrimSpace(claims.Scope) == "" {
+		return fmt.Errorf("%w: missing scope", ErrInvalidClaims)
+	}
+
+	return nil
+}
+
+// containsURL reports whether want is in list, ignoring case and a trailing slash.
+func containsURL(list []string, want string) bool {
+	want = strings.TrimSuffix(want, "/")
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSuffix(v, "/"), want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+const (
+	testIssuer    = "https://auth.example.com/"
+	testMe        = "https://me.example.com/"
+	testPublicUrl = "https://scribble.example.com"
+)
+
+type testKeys struct {
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	ed    ed25519.PrivateKey
+	rogue *rsa.PrivateKey
+}
+
+// keys generates the signing keys once; RSA key generation is slow enough to matter per test.
+var keys = sync.OnceValue(func() *testKeys {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rogue, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	return &testKeys{rsa: rsaKey, ec: ecKey, ed: edKey, rogue: rogue}
+})
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// writeJwks writes the public halves of the test keys to a JWKS file.
+func (k *testKeys) writeJwks(t *testing.T) string {
+	t.Helper()
+
+	ecPub := k.ec.PublicKey
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "alg": "ES256", "crv": "P-256", "x": b64(ecPub.X.FillBytes(make([]byte, 32))), "y": b64(ecPub.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "alg": "EdDSA", "crv": "Ed25519", "x": b64(k.ed.Public().(ed25519.PublicKey))},
+	}}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func validClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":       testIssuer,
+		"aud":       testPublicUrl,
+		"exp":       now.Add(time.Hour).Unix(),
+		"iat":       now.Unix(),
+		"me":        testMe,
+		"scope":     "create media",
+		"client_id": "https://client.example.com/",
+	}
+}
+
+// sign produces a compact JWS over the header and claims. The signing input is passed to signer,
+// whose result becomes the signature.
+func sign(t *testing.T, header map[string]any, claims map[string]any, signer func(input []byte) []byte) string {
+	t.Helper()
+
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(c)
+
+	return input + "." + b64(signer([]byte(input)))
+}
+
+func rs256(key *rsa.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return sig
+	}
+}
+
+func es256(key *ecdsa.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+}
+
+func eddsa(key ed25519.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		return ed25519.Sign(key, input)
+	}
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func jwtSite(jwks string) *config.Micropub {
+	return &config.Micropub{
+		MeUrl:     testMe,
+		PublicUrl: testPublicUrl,
+		TokenVerification: config.TokenVerification{
+			Mode:      "jwt",
+			JwksFile:  jwks,
+			Issuer:    testIssuer,
+			ClockSkew: 30 * time.Second,
+		},
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	k := keys()
+	jwks := k.writeJwks(t)
+
+	with := func(changes map[string]any) map[string]any {
+		claims := validClaims()
+		for key, v := range changes {
+			if v == nil {
+				delete(claims, key)
+			} else {
+				claims[key] = v
+			}
+		}
+		return claims
+	}
+
+	now := time.Now()
+	rsaHeader := map[string]any{"alg": "RS256", "kid": "rsa"}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"rs256", sign(t, rsaHeader, validClaims(), rs256(k.rsa)), nil},
+		{"es256", sign(t, map[string]any{"alg": "ES256", "kid": "ec"}, validClaims(), es256(k.ec)), nil},
+		{"eddsa", sign(t, map[string]any{"alg": "EdDSA", "kid": "ed"}, validClaims(), eddsa(k.ed)), nil},
+		{"no kid", sign(t, map[string]any{"alg": "RS256"}, validClaims(), rs256(k.rsa)), nil},
+		{"audience list", sign(t, rsaHeader, with(map[string]any{"aud": []string{"https://other.example/", testPublicUrl + "/"}}), rs256(k.rsa)), nil},
+		{"expired within skew", sign(t, rsaHeader, with(map[string]any{"exp": now.Add(-10 * time.Second).Unix()}), rs256(k.rsa)), nil},
+
+		{"alg none", sign(t, map[string]any{"alg": "none", "kid": "rsa"}, validClaims(), func([]byte) []byte { return nil }), ErrUnsupportedAlg},
+		{"alg missing", sign(t, map[string]any{"kid": "rsa"}, validClaims(), rs256(k.rsa)), ErrUnsupportedAlg},
+		{"hs256 keyed with rsa public key", sign(t, map[string]any{"alg": "HS256", "kid": "rsa"}, validClaims(), hs256(k.rsa.N.Bytes())), ErrUnsupportedAlg},
+		{"hs256 keyed with eddsa public key", sign(t, map[string]any{"alg": "HS256", "kid": "ed"}, validClaims(), hs256(k.ed.Public().(ed25519.PublicKey))), ErrUnsupportedAlg},
+		{"alg differs from key", sign(t, map[string]any{"alg": "ES256", "kid": "rsa"}, validClaims(), es256(k.ec)), ErrUnknownKey},
+		{"unknown kid", sign(t, map[string]any{"alg": "RS256", "kid": "rotated-away"}, validClaims(), rs256(k.rsa)), ErrUnknownKey},
+
+		{"signed by another key", sign(t, rsaHeader, validClaims(), rs256(k.rogue)), ErrInvalidSig},
+		{"empty signature", sign(t, rsaHeader, validClaims(), func([]byte) []byte { return nil }), ErrInvalidSig},
+		{"truncated es256 signature", sign(t, map[string]any{"alg": "ES256", "kid": "ec"}, validClaims(), func(input []byte) []byte { return es256(k.ec)(input)[:63] }), ErrInvalidSig},
+
+		{"expired", sign(t, rsaHeader, with(map[string]any{"exp": now.Add(-time.Minute).Unix()}), rs256(k.rsa)), ErrInvalidClaims},
+		{"no exp", sign(t, rsaHeader, with(map[string]any{"exp": nil}), rs256(k.rsa)), ErrInvalidClaims},
+		{"not yet valid", sign(t, rsaHeader, with(map[string]any{"nbf": now.Add(time.Minute).Unix()}), rs256(k.rsa)), ErrInvalidClaims},
+		{"wrong issuer", sign(t, rsaHeader, with(map[string]any{"iss": "https://evil.example/"}), rs256(k.rsa)), ErrInvalidClaims},
+		{"wrong audience", sign(t, rsaHeader, with(map[string]any{"aud": "https://other.example/"}), rs256(k.rsa)), ErrInvalidClaims},
+		{"no audience", sign(t, rsaHeader, with(map[string]any{"aud": nil}), rs256(k.rsa)), ErrInvalidClaims},
+		{"another me", sign(t, rsaHeader, with(map[string]any{"me": "https://someone.else/"}), rs256(k.rsa)), ErrInvalidClaims},
+		{"no scope", sign(t, rsaHeader, with(map[string]any{"scope": " "}), rs256(k.rsa)), ErrInvalidClaims},
+
+		{"malformed header", "not-json." + b64([]byte("{}")) + ".sig", ErrMalformedJWT},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := verifyJWT(jwtSite(jwks), testPublicUrl, tt.token)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if details.Me != testMe || details.Scope != "create media" {
+					t.Errorf("details = %v", details)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if details != nil {
+				t.Errorf("details = %v, want none", details)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTTamperedPayload(t *testing.T) {
+	k := keys()
+	token := sign(t, map[string]any{"alg": "RS256", "kid": "rsa"}, validClaims(), rs256(k.rsa))
+
+	escalated := validClaims()
+	escalated["scope"] = "create update delete media"
+	c, _ := json.Marshal(escalated)
+
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + b64(c) + "." + parts[2]
+
+	if _, err := verifyJWT(jwtSite(k.writeJwks(t)), testPublicUrl, tampered); !errors.Is(err, ErrInvalidSig) {
+		t.Errorf("err = %v, want ErrInvalidSig", err)
+	}
+}
+
+func TestVerifyJWTRejectsShortRsaKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "weak", "n": b64(weak.N.Bytes()), "e": b64(big.NewInt(int64(weak.E)).Bytes())},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	token := sign(t, map[string]any{"alg": "RS256", "kid": "weak"}, validClaims(), rs256(weak))
+	if _, err := verifyJWT(jwtSite(path), testPublicUrl, token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerifyAccessTokenJWTFallback(t *testing.T) {
+	k := keys()
+
+	var calls atomic.Int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(TokenDetails{Me: testMe, Scope: "create"})
+	}))
+	defer endpoint.Close()
+
+	site := jwtSite(k.writeJwks(t))
+	site.TokenEndpoint = endpoint.URL
+
+	forged := sign(t, map[string]any{"alg": "RS256", "kid": "rsa"}, validClaims(), rs256(k.rogue))
+	foreign := validClaims()
+	foreign["iss"] = "https://other-issuer.example/"
+	foreignToken := sign(t, map[string]any{"alg": "RS256", "kid": "other"}, foreign, rs256(k.rogue))
+
+	tests := []struct {
+		name     string
+		fallback bool
+		token    string
+		accepted bool
+		calls    int32
+	}{
+		{"forged jwt without fallback", false, forged, false, 0},
+		{"forged jwt from own issuer is not sent remotely", true, forged, false, 0},
+		{"jwt from another issuer falls back", true, foreignToken, true, 1},
+		{"opaque token falls back", true, "opaque-token", true, 1},
+		{"opaque token without fallback", false, "opaque-token", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			site.TokenVerification.FallbackRemote = tt.fallback
+
+			details, err := VerifyAccessToken(context.Background(), hosting(*site), "", tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (details != nil) != tt.accepted {
+				t.Errorf("accepted = %v, want %v", details != nil, tt.accepted)
+			}
+			if calls.Load() != tt.calls {
+				t.Errorf("token endpoint calls = %d, want %d", calls.Load(), tt.calls)
+			}
+		})
+	}
+}
+
+func TestVerifyAccessTokenSendsJWTOnlyToTheSiteItNames(t *testing.T) {
+	k := keys()
+
+	var calls atomic.Int32
+	other := tokenEndpoint(t, "https://other.example/", &calls)
+
+	cfg := hosting(config.Micropub{MeUrl: "https://other.example/", TokenEndpoint: other.URL}, *jwtSite(k.writeJwks(t)))
+	token := sign(t, map[string]any{"alg": "RS256", "kid": "rsa"}, validClaims(), rs256(k.rsa))
+
+	details, err := VerifyAccessToken(context.Background(), cfg, "", token)
+	if err != nil || details == nil || details.Me != testMe {
+		t.Fatalf("details = %v, err = %v", details, err)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("another site's token endpoint was shown the token %d times", calls.Load())
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+// defaultImplications applies when a site does not configure its own implication rules. Many clients
+// only request "create", so it also grants drafting and uploading media.
+var defaultImplications = map[string][]string{
+	ScopeCreate.String(): {ScopeDraft.String(), ScopeMedia.String()},
+}
+
+// ScopePolicy decides which scopes a token effectively holds for a site and which posts its client
+// may create.
+type ScopePolicy struct {
+	implies map[string][]string
+	clients []config.ClientPolicy
+}
+
+func NewScopePolicy(cfg *config.ScopePolicy) *ScopePolicy {
+	implies := defaultImplications
+	if cfg.Implies != nil {
+		implies = make(map[string][]string, len(cfg.Implies))
+		for scope, granted := range cfg.Implies {
+			implies[strings.ToLower(scope)] = lowerAll(granted)
+		}
+	}
+
+	return &ScopePolicy{implies: implies, clients: cfg.Clients}
+}
+
+// Granted reports whether the token may use the scope. The token must hold the scope, directly or
+// through an implication rule, and its client must not be barred from using it.
+func (p *ScopePolicy) Granted(token *TokenDetails, scope Scope) bool {
+	if token == nil {
+		return false
+	}
+
+	name := scope.String()
+	if client := p.clientPolicy(token.ClientId); client != nil {
+		if slices.Contains(lowerAll(client.Deny), name) {
+			return false
+		}
+		if len(client.Allow) > 0 && !slices.Contains(lowerAll(client.Allow), name) {
+			return false
+		}
+	}
+
+	return slices.Contains(p.expand(token.Scopes()), name)
+}
+
+// RestrictsPostTypes reports whether the token's client may only create some types of post.
+func (p *ScopePolicy) RestrictsPostTypes(token *TokenDetails) bool {
+	client := p.clientPolicy(token.ClientId)
+	return client != nil && len(client.PostTypes) > 0
+}
+
+// AllowsPostType reports whether the token's client may create posts of the given type.
+func (p *ScopePolicy) AllowsPostType(token *TokenDetails, postType string) bool {
+	client := p.clientPolicy(token.ClientId)
+	if client == nil || len(client.PostTypes) == 0 {
+		return true
+	}
+
+	return slices.Contains(lowerAll(client.PostTypes), strings.ToLower(postType))
+}
+
+// AllowsChannel reports whether the token's client may post to the given channel. An empty channel
+// (no mp-channel given) is always allowed.
+func (p *ScopePolicy) AllowsChannel(token *TokenDetails, channel string) bool {
+	client := p.clientPolicy(token.ClientId)
+	if client == nil || len(client.Channels) == 0 || channel == "" {
+		return true
+	}
+
+	return slices.Contains(client.Channels, channel)
+}
+
+// expand returns the scopes along with every scope they imply, following implication chains.
+func (p *ScopePolicy) expand(scopes []string) []string {
+	out := slices.Clone(scopes)
+	for i := 0; i < len(out); i++ {
+		for _, implied := range p.implies[out[i]] {
+			if !slices.Contains(out, implied) {
+				out = append(out, implied)
+			}
+		}
+	}
+
+	return out
+}
+
+func (p *ScopePolicy) clientPolicy(clientId string) *config.ClientPolicy {
+	if clientId == "" {
+		return nil
+	}
+
+	want := strings.TrimSuffix(clientId, "/")
+	for i := range p.clients {
+		if strings.EqualFold(strings.TrimSuffix(p.clients[i].ClientId, "/"), want) {
+			return &p.clients[i]
+		}
+	}
+
+	return nil
+}
+
+func lowerAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(v)
+	}
+	return out
+}
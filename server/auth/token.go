@@ -2,16 +2,22 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/metrics"
+	"github.com/indieinfra/scribble/server/tracing"
 )
 
 type tokenKeyType struct{}
@@ -24,6 +30,9 @@ type TokenDetails struct {
 	Scope    string `json:"scope"`
 	IssuedAt uint   `json:"issued_at"`
 	Nonce    int    `json:"nonce"`
+
+	// Hash is a SHA-256 digest of the raw token, usable as a key without retaining the token itself.
+	Hash string `json:"-"`
 }
 
 // ExtractBearerToken extracts a Bearer token from an Authorization header value.
@@ -86,8 +95,13 @@ func (details *TokenDetails) String() string {
 	return fmt.Sprintf("TokenDetails{me=%v, clientId=%v, scope=%v, issuedAt=%v, nonce=%v}", details.Me, details.ClientId, details.Scope, details.IssuedAt, details.Nonce)
 }
 
+// Scopes returns the scopes literally granted to the token, lowercased.
+func (details *TokenDetails) Scopes() []string {
+	return strings.Fields(strings.ToLower(details.Scope))
+}
+
 func (details *TokenDetails) HasScope(scope Scope) bool {
-	return slices.Contains(strings.Split(strings.ToLower(details.Scope), " "), strings.ToLower(scope.String()))
+	return slices.Contains(details.Scopes(), strings.ToLower(scope.String()))
 }
 
 func (details *TokenDetails) HasMe(me string) bool {
@@ -101,13 +115,170 @@ var (
 	ErrTokenEndpointFail = errors.New("failed to contact token endpoint")
 )
 
-func VerifyAccessToken(cfg *config.Config, token string) (*TokenDetails, error) {
+// VerifyAccessToken validates a token for one of the sites hosted by this instance; the caller picks
+// the site from the "me" of the details returned. The token is only shown to the verifier of a site it
+// may belong to (see tokenSites), and accepted when the "me" it was issued for is one of theirs. host
+// is the host the request was made to. A nil TokenDetails with a nil error means the token was
+// rejected.
+func VerifyAccessToken(ctx context.Context, cfg *config.Config, host string, token string) (*TokenDetails, error) {
 	if token == "" {
 		return nil, ErrEmptyToken
 	}
 
-	tokenEndpointUrl := cfg.Micropub.TokenEndpoint
-	req, err := http.NewRequest(http.MethodGet, tokenEndpointUrl, nil)
+	ctx, span := tracing.Start(ctx, "auth.VerifyAccessToken", tracing.KindInternal)
+	defer span.End()
+
+	details, err := verifyForSites(ctx, cfg, host, token)
+	span.RecordError(err)
+	if details != nil {
+		span.SetAttribute("scribble.client_id", details.ClientId)
+		sum := sha256.Sum256([]byte(token))
+		details.Hash = hex.EncodeToString(sum[:])
+	}
+
+	return details, err
+}
+
+func verifyForSites(ctx context.Context, cfg *config.Config, host string, token string) (*TokenDetails, error) {
+	sites := tokenSites(cfg.AllSites(), host, token)
+	switch len(sites) {
+	case 0:
+		slog.DebugContext(ctx, "token cannot be attributed to a site hosted here", "host", host)
+		return nil, nil
+	case 1:
+		publicUrl := sites[0].Micropub.PublicUrl
+		if publicUrl == "" {
+			publicUrl = cfg.Server.PublicUrl
+		}
+		return verifyForSite(ctx, &sites[0].Micropub, publicUrl, token)
+	}
+
+	// The sites share a token endpoint, which is asked once.
+	details, err := verifyAtEndpoint(ctx, sites[0].Micropub.TokenEndpoint, token)
+	if err != nil {
+		metrics.ObserveTokenVerification("remote", "error")
+		return nil, err
+	}
+	if details != nil {
+		for _, site := range sites {
+			if details.HasMe(site.Micropub.MeUrl) {
+				metrics.ObserveTokenVerification("remote", "accepted")
+				return details, nil
+			}
+		}
+		slog.DebugContext(ctx, "received a valid token that does not belong to a site hosted here", "me", details.Me)
+	}
+
+	metrics.ObserveTokenVerification("remote", "rejected")
+	return nil, nil
+}
+
+// tokenSites returns the sites a token may belong to, judged without showing the token to anyone. A
+// JWT names its site in its me claim. Any other token is for the token endpoint of the sites that
+// check tokens remotely, provided they all use the same one. When they use different endpoints, the
+// token would have to be shown to the wrong ones to find its site, so the site is told apart by the
+// host the request was made to instead, which works once each of them has a public_url of its own.
+// No sites are returned when the token cannot be attributed.
+func tokenSites(sites []config.Site, host string, token string) []config.Site {
+	if me := claimsMe(token); me != "" {
+		claimed := TokenDetails{Me: me}
+		for _, site := range sites {
+			if claimed.HasMe(site.Micropub.MeUrl) {
+				return []config.Site{site}
+			}
+		}
+
+		return nil
+	}
+
+	var remote []config.Site
+	for _, site := range sites {
+		if usesRemoteVerification(&site.Micropub) {
+			remote = append(remote, site)
+		}
+	}
+
+	shared := !slices.ContainsFunc(remote, func(site config.Site) bool {
+		return site.Micropub.TokenEndpoint != remote[0].Micropub.TokenEndpoint
+	})
+	if shared {
+		return remote
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+
+	var served []config.Site
+	for _, site := range remote {
+		u, err := url.Parse(site.Micropub.PublicUrl)
+		if err != nil || u.Hostname() == "" {
+			return nil
+		}
+		if strings.EqualFold(u.Hostname(), host) {
+			served = append(served, site)
+		}
+	}
+	if len(served) != 1 {
+		return nil
+	}
+
+	return served
+}
+
+func verifyForSite(ctx context.Context, site *config.Micropub, publicUrl string, token string) (*TokenDetails, error) {
+	if site.TokenVerification.Mode == "jwt" && LooksLikeJWT(token) {
+		details, err := verifyJWT(site, publicUrl, token)
+		if err == nil {
+			metrics.ObserveTokenVerification("jwt", "accepted")
+			return details, nil
+		}
+
+		if errors.Is(err, ErrKeySetFetchFail) {
+			metrics.ObserveTokenVerification("jwt", "error")
+			return nil, err
+		}
+
+		metrics.ObserveTokenVerification("jwt", "rejected")
+		slog.DebugContext(ctx, "jwt rejected", "site", site.MeUrl, "error", err)
+
+		// A token the site's own issuer signed is settled by the local check; only tokens from
+		// elsewhere may fall back to the token endpoint.
+		if claimsIssuer(token) == site.TokenVerification.Issuer {
+			return nil, nil
+		}
+	}
+
+	if !usesRemoteVerification(site) {
+		return nil, nil
+	}
+
+	details, err := verifyAtEndpoint(ctx, site.TokenEndpoint, token)
+	if err != nil {
+		metrics.ObserveTokenVerification("remote", "error")
+		return nil, err
+	}
+	if details == nil || !details.HasMe(site.MeUrl) {
+		metrics.ObserveTokenVerification("remote", "rejected")
+		if details != nil {
+			slog.DebugContext(ctx, "token endpoint vouched for a token issued for another site", "me", details.Me, "site", site.MeUrl)
+		}
+		return nil, nil
+	}
+
+	metrics.ObserveTokenVerification("remote", "accepted")
+	return details, nil
+}
+
+// usesRemoteVerification reports whether tokens for the site may be checked at its token endpoint.
+func usesRemoteVerification(site *config.Micropub) bool {
+	tv := &site.TokenVerification
+	return tv.Mode != "jwt" || tv.FallbackRemote
+}
+
+func verifyAtEndpoint(ctx context.Context, tokenEndpointUrl string, token string) (*TokenDetails, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenEndpointUrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create http request for token endpoint: %w", err)
 	}
@@ -117,19 +288,20 @@ func VerifyAccessToken(cfg *config.Config, token string) (*TokenDetails, error)
 
 	// Create HTTP client with 10 second timeout
 	client := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: tracing.Transport(nil),
 	}
 
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.ObserveTokenEndpoint(tokenEndpointUrl, time.Since(start))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrTokenEndpointFail, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		if cfg.Debug {
-			log.Printf("debug: token failed validation at token endpoint (%q)", token)
-		}
+		slog.DebugContext(ctx, "token failed validation at token endpoint", "endpoint", tokenEndpointUrl, "status", resp.StatusCode)
 
 		return nil, nil
 	}
@@ -137,20 +309,12 @@ func VerifyAccessToken(cfg *config.Config, token string) (*TokenDetails, error)
 	details := &TokenDetails{}
 	err = json.NewDecoder(resp.Body).Decode(details)
 	if err != nil {
-		log.Println(fmt.Errorf("warning: token endpoint provided bad data, can not verify token: %w", err))
+		slog.WarnContext(ctx, "token endpoint provided bad data, can not verify token", "endpoint", tokenEndpointUrl, "error", err)
 		return nil, nil
 	}
 
 	if details.Me == "" {
-		log.Println("warning: token endpoint did not include \"me\" information - cannot verify token")
-		return nil, nil
-	}
-
-	if !details.HasMe(cfg.Micropub.MeUrl) {
-		if cfg.Debug {
-			log.Printf("debug: received a valid token that did not belong to this instance! (%q)\n", token)
-		}
-
+		slog.WarnContext(ctx, "token endpoint did not include \"me\" information, can not verify token", "endpoint", tokenEndpointUrl)
 		return nil, nil
 	}
 
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+// tokenEndpoint vouches for every token as belonging to me, counting the tokens it is shown.
+func tokenEndpoint(t *testing.T, me string, seen *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Add(1)
+		json.NewEncoder(w).Encode(TokenDetails{Me: me, ClientId: "https://client.example/", Scope: "create"})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// hosting returns a configuration hosting the given sites, the first as the primary one.
+func hosting(sites ...config.Micropub) *config.Config {
+	cfg := &config.Config{Micropub: sites[0]}
+	cfg.Server.PublicUrl = "https://scribble.example.com"
+	for _, site := range sites[1:] {
+		cfg.Sites = append(cfg.Sites, config.Site{Micropub: site})
+	}
+
+	return cfg
+}
+
+func TestVerifyAccessTokenPicksSiteByMe(t *testing.T) {
+	var seen atomic.Int32
+	endpoint := tokenEndpoint(t, "https://b.example/", &seen)
+
+	cfg := hosting(
+		config.Micropub{MeUrl: "https://a.example/", TokenEndpoint: endpoint.URL},
+		config.Micropub{MeUrl: "https://b.example/", TokenEndpoint: endpoint.URL},
+	)
+
+	// Sites sharing a token endpoint are served at any host, and the endpoint is asked once.
+	details, err := VerifyAccessToken(context.Background(), cfg, "anywhere.example", "token-for-b")
+	if err != nil || details == nil || details.Me != "https://b.example/" {
+		t.Fatalf("details = %v, err = %v", details, err)
+	}
+	if seen.Load() != 1 {
+		t.Errorf("endpoint calls = %d, want 1", seen.Load())
+	}
+
+	cfg.Sites = nil
+	if details, err := VerifyAccessToken(context.Background(), cfg, "anywhere.example", "token-for-b"); details != nil || err != nil {
+		t.Errorf("token for a site not hosted here: details = %v, err = %v", details, err)
+	}
+}
+
+func TestVerifyAccessTokenNeverShowsTokenToAnotherSitesEndpoint(t *testing.T) {
+	var seenA, seenB atomic.Int32
+	endpointA := tokenEndpoint(t, "https://a.example/", &seenA)
+	endpointB := tokenEndpoint(t, "https://b.example/", &seenB)
+
+	siteA := config.Micropub{MeUrl: "https://a.example/", TokenEndpoint: endpointA.URL}
+	siteB := config.Micropub{MeUrl: "https://b.example/", TokenEndpoint: endpointB.URL}
+
+	// Without a host of their own the sites cannot be told apart, so the token is sent nowhere.
+	if details, err := VerifyAccessToken(context.Background(), hosting(siteA, siteB), "scribble.example.com", "t"); details != nil || err != nil {
+		t.Errorf("details = %v, err = %v", details, err)
+	}
+	if seenA.Load() != 0 || seenB.Load() != 0 {
+		t.Errorf("endpoint calls: a = %d, b = %d; want none", seenA.Load(), seenB.Load())
+	}
+
+	siteA.PublicUrl = "https://scribble.a.example"
+	siteB.PublicUrl = "https://Scribble.B.example:8443/"
+	cfg := hosting(siteA, siteB)
+
+	details, err := VerifyAccessToken(context.Background(), cfg, "scribble.b.example:8443", "t")
+	if err != nil || details == nil || details.Me != "https://b.example/" {
+		t.Fatalf("details = %v, err = %v", details, err)
+	}
+	if seenA.Load() != 0 || seenB.Load() != 1 {
+		t.Errorf("endpoint calls: a = %d, b = %d; want 0 and 1", seenA.Load(), seenB.Load())
+	}
+
+	if details, err := VerifyAccessToken(context.Background(), cfg, "elsewhere.example", "t"); details != nil || err != nil {
+		t.Errorf("unknown host: details = %v, err = %v", details, err)
+	}
+	if seenA.Load() != 0 || seenB.Load() != 1 {
+		t.Errorf("endpoint calls: a = %d, b = %d; want 0 and 1", seenA.Load(), seenB.Load())
+	}
+}
+
+func TestVerifyAccessTokenReportsEndpointFailure(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := hosting(config.Micropub{MeUrl: "https://down.example/", TokenEndpoint: down.URL})
+	if _, err := VerifyAccessToken(context.Background(), cfg, "", "t"); err == nil {
+		t.Error("expected an error from an unreachable endpoint")
+	}
+}
+
+func TestVerifyAccessTokenRejectsEndpointRefusal(t *testing.T) {
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer refusing.Close()
+
+	cfg := hosting(config.Micropub{MeUrl: "https://a.example/", TokenEndpoint: refusing.URL})
+	details, err := VerifyAccessToken(context.Background(), cfg, "", "t")
+	if details != nil || err != nil {
+		t.Errorf("details = %v, err = %v; want a clean rejection", details, err)
+	}
+}
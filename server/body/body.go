@@ -2,8 +2,9 @@ package body
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -75,9 +76,11 @@ func (p *QueryParams) Add(key string, value []string) {
 	}
 }
 
+// ParsedBody is a request body read for the Micropub endpoint. For multipart bodies, Data holds the
+// fields sent before the first file, and the files are left in Uploads to be streamed by the handler.
 type ParsedBody struct {
 	Data        map[string]any
-	Files       []util.MultipartFile
+	Uploads     *util.MultipartStream
 	AccessToken string
 }
 
@@ -161,19 +164,29 @@ func readFormURLEncoded(cfg *config.Config, w http.ResponseWriter, r *http.Reque
 	return out
 }
 
-// readMultipart parses a multipart/form-data request body, extracting both
-// form fields and uploaded files.
+// readMultipart starts reading a multipart/form-data request body, reading the form fields up to the
+// first file. Files are not buffered; the body may be no larger than the form fields' memory budget
+// plus the largest file allowed.
 func readMultipart(cfg *config.Config, w http.ResponseWriter, r *http.Request) (*ParsedBody, bool) {
-	maxMemory := int64(cfg.Server.Limits.MaxMultipartMem)
-	maxFileSize := int64(cfg.Server.Limits.MaxFileSize)
+	limits := &cfg.Server.Limits
 
-	parsed, err := util.ParseMultipart(w, r, maxMemory, maxFileSize)
+	stream, err := util.NewMultipartStream(w, r, int64(limits.MaxMultipartMem), util.LargestFileSize(limits))
+	if err == nil {
+		err = stream.ReadFields()
+	}
 	if err != nil {
-		log.Println("Error parsing multipart body:", err)
+		slog.WarnContext(r.Context(), "error parsing multipart body", "error", err)
+		switch {
+		case errors.As(err, new(*http.MaxBytesError)), errors.Is(err, util.ErrFieldsTooLarge):
+			resp.WriteTooLarge(w, "request body too large")
+		default:
+			resp.WriteInvalidRequest(w, "Invalid multipart body")
+		}
 		return nil, false
 	}
 
-	token := auth.PopAccessToken(parsed.Values)
+	data := stream.Values()
+	token := auth.PopAccessToken(data)
 
-	return &ParsedBody{Data: parsed.Values, Files: parsed.Files, AccessToken: token}, true
+	return &ParsedBody{Data: data, Uploads: stream, AccessToken: token}, true
 }
@@ -0,0 +1,271 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+)
+
+const (
+	defaultDebounce = 5 * time.Second
+	defaultTimeout  = 10 * time.Minute
+	// maxOutput bounds how much of a build's combined output is kept; the tail is the useful part.
+	maxOutput = 64 << 10
+)
+
+// State describes what the runner is doing.
+type State string
+
+const (
+	StateIdle    State = "idle"
+	StatePending State = "pending"
+	StateRunning State = "running"
+)
+
+// Result records the outcome of a finished build.
+type Result struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   string    `json:"duration"`
+	Events     int       `json:"events"`
+	Success    bool      `json:"success"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Status is a snapshot of the runner, as reported by q=build.
+type Status struct {
+	State         State   `json:"state"`
+	PendingEvents int     `json:"pending_events"`
+	LastBuild     *Result `json:"last_build,omitempty"`
+}
+
+// Runner runs a site's build command after content changes. Events arriving within the debounce
+// window of each other are coalesced into a single build, and events arriving while a build runs
+// trigger one more build once it finishes.
+type Runner struct {
+	me       string
+	cfg      *config.BuildHook
+	debounce time.Duration
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	pending  []events.Event
+	lastCtx  context.Context
+	timer    *time.Timer
+	running  bool
+	closed   bool
+	last     *Result
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewRunner(me string, cfg *config.BuildHook) *Runner {
+	r := &Runner{me: me, cfg: cfg, debounce: cfg.Debounce, timeout: cfg.Timeout}
+	if r.debounce <= 0 {
+		r.debounce = defaultDebounce
+	}
+	if r.timeout <= 0 {
+		r.timeout = defaultTimeout
+	}
+
+	return r
+}
+
+// Handle schedules a build for the event, restarting the debounce window.
+func (r *Runner) Handle(ctx context.Context, ev events.Event) {
+	if len(r.cfg.Events) > 0 && !slices.Contains(r.cfg.Events, string(ev.Type)) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.pending = append(r.pending, ev)
+	r.lastCtx = ctx
+
+	if !r.running {
+		r.schedule()
+	}
+}
+
+// schedule (re)starts the debounce timer. Callers must hold r.mu.
+func (r *Runner) schedule() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(r.debounce, r.start)
+}
+
+func (r *Runner) start() {
+	r.mu.Lock()
+	if r.running || r.closed || len(r.pending) == 0 {
+		r.mu.Unlock()
+		return
+	}
+
+	batch := r.pending
+	ctx := r.lastCtx
+	r.pending = nil
+	r.running = true
+	r.finished = make(chan struct{})
+
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+	r.cancel = cancel
+	r.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		result := r.run(runCtx, ctx, batch)
+
+		r.mu.Lock()
+		r.last = result
+		r.running = false
+		r.cancel = nil
+		close(r.finished)
+		if len(r.pending) > 0 && !r.closed {
+			r.schedule()
+		}
+		r.mu.Unlock()
+	}()
+}
+
+func (r *Runner) run(ctx context.Context, logCtx context.Context, batch []events.Event) *Result {
+	result := &Result{StartedAt: time.Now().UTC(), Events: len(batch)}
+	slog.InfoContext(logCtx, "starting build", "command", r.cfg.Command, "events", len(batch))
+
+	cmd := exec.CommandContext(ctx, r.cfg.Command[0], r.cfg.Command[1:]...)
+	cmd.Dir = r.cfg.Dir
+	cmd.Env = append(os.Environ(), r.env(batch)...)
+	killProcessGroup(cmd)
+	// Don't let a stray background process holding the output pipe keep the build "running" forever.
+	cmd.WaitDelay = 5 * time.Second
+
+	output := &tailBuffer{max: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+
+	result.FinishedAt = time.Now().UTC()
+	result.Duration = result.FinishedAt.Sub(result.StartedAt).Round(time.Millisecond).String()
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	result.Success = err == nil
+
+	attrs := []any{"exit_code", result.ExitCode, "duration", result.Duration, "output", output.String()}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = errors.New("build timed out after " + r.timeout.String())
+		}
+		result.Error = err.Error()
+		slog.ErrorContext(logCtx, "build failed", append(attrs, "error", err)...)
+	} else {
+		slog.InfoContext(logCtx, "build finished", attrs...)
+	}
+
+	return result
+}
+
+// env describes the events that triggered the build. The single-event variables describe the most
+// recent event; SCRIBBLE_URLS lists every distinct URL in the batch, one per line.
+func (r *Runner) env(batch []events.Event) []string {
+	latest := batch[len(batch)-1]
+
+	var urls []string
+	for _, ev := range batch {
+		if ev.Url != "" && !slices.Contains(urls, ev.Url) {
+			urls = append(urls, ev.Url)
+		}
+	}
+
+	return []string{
+		"SCRIBBLE_ME=" + r.me,
+		"SCRIBBLE_EVENT=" + string(latest.Type),
+		"SCRIBBLE_EVENT_ID=" + latest.Id,
+		"SCRIBBLE_URL=" + latest.Url,
+		"SCRIBBLE_CLIENT_ID=" + latest.ClientId,
+		"SCRIBBLE_EVENT_COUNT=" + strconv.Itoa(len(batch)),
+		"SCRIBBLE_URLS=" + strings.Join(urls, "\n"),
+	}
+}
+
+// Status reports whether a build is pending or running, and the outcome of the last one.
+func (r *Runner) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{State: StateIdle, PendingEvents: len(r.pending), LastBuild: r.last}
+	switch {
+	case r.running:
+		status.State = StateRunning
+	case len(r.pending) > 0:
+		status.State = StatePending
+	}
+
+	return status
+}
+
+// Close drops pending builds and waits for a running build to finish, killing it if ctx expires first.
+func (r *Runner) Close(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	running, finished, cancel := r.running, r.finished, r.cancel
+	r.mu.Unlock()
+
+	if !running {
+		return nil
+	}
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf.Write(p)
+	if over := t.buf.Len() - t.max; over > 0 {
+		t.buf.Next(over)
+	}
+
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return strings.TrimSpace(t.buf.String())
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package build
+
+import "os/exec"
+
+// killProcessGroup is a no-op where process groups are unavailable; only the build command itself is
+// killed, not processes it started.
+func killProcessGroup(cmd *exec.Cmd) {}
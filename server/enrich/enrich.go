@@ -0,0 +1,247 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/fetch"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/media"
+	storageutil "github.com/indieinfra/scribble/storage/util"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultMaxBytes = 1 << 20
+	// contentWords bounds the quoted text stored in a reply context.
+	contentWords = 300
+)
+
+// ErrDomainNotAllowed is returned when a URL's host is excluded by the allow or deny lists.
+var ErrDomainNotAllowed = errors.New("domain not allowed for enrichment")
+
+// defaultReplyProperties are the properties whose bare URLs are expanded into reply contexts.
+var defaultReplyProperties = []string{"in-reply-to", "like-of", "repost-of"}
+
+// Enricher fetches the pages a new post refers to and embeds what it finds in the post.
+type Enricher struct {
+	cfg          *config.Enrich
+	client       *http.Client
+	maxBytes     int64
+	media        media.Store
+	mediaPattern *storageutil.PathPattern
+}
+
+// New creates an enricher. The media store and path pattern are used to archive preview images.
+func New(cfg *config.Enrich, mediaStore media.Store, mediaPattern *storageutil.PathPattern) *Enricher {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBytes
+	}
+
+	return &Enricher{
+		cfg:          cfg,
+		client:       fetch.NewClient(fetch.Options{Timeout: timeout, AllowPrivate: cfg.AllowPrivate}),
+		maxBytes:     maxBytes,
+		media:        mediaStore,
+		mediaPattern: mediaPattern,
+	}
+}
+
+// Enrich expands the document's references in place. Failures are logged and leave the original
+// value untouched, so enrichment never prevents a post from being created.
+func (e *Enricher) Enrich(ctx context.Context, doc *util.Mf2Document) {
+	// Previews run first so that, for a property both are configured for, the richer card metadata
+	// wins; reply contexts skip values that are already expanded.
+	if e.cfg.LinkPreview.Enabled {
+		properties := e.cfg.LinkPreview.Properties
+		if len(properties) == 0 {
+			properties = defaultPreviewProperties
+		}
+
+		e.expand(ctx, doc, properties, e.linkPreview)
+	}
+
+	if e.cfg.ReplyContext.Enabled {
+		properties := e.cfg.ReplyContext.Properties
+		if len(properties) == 0 {
+			properties = defaultReplyProperties
+		}
+
+		e.expand(ctx, doc, properties, e.replyContext)
+	}
+}
+
+// expand replaces each bare URL value of the given properties with the h-cite built for it. Pages are
+// fetched concurrently.
+func (e *Enricher) expand(ctx context.Context, doc *util.Mf2Document, properties []string, build func(context.Context, string) (map[string]any, error)) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, prop := range properties {
+		for i, v := range doc.Properties[prop] {
+			target, ok := v.(string)
+			if !ok {
+				// Clients that already supplied an h-cite know better than we do.
+				continue
+			}
+
+			wg.Go(func() {
+				cite, err := build(ctx, target)
+				if err != nil {
+					slog.WarnContext(ctx, "could not enrich post", "property", prop, "url", target, "error", err)
+					return
+				}
+
+				mu.Lock()
+				doc.Properties[prop][i] = cite
+				mu.Unlock()
+			})
+		}
+	}
+
+	wg.Wait()
+}
+
+// replyContext fetches target and describes it as an h-cite built from its h-entry (name, content,
+// published and author), falling back to the page title.
+func (e *Enricher) replyContext(ctx context.Context, target string) (map[string]any, error) {
+	page, base, err := e.fetchHtml(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[string]any{"url": []any{target}}
+
+	if root := findItem(page, "h-entry", "h-cite"); root != nil {
+		entry := parseItem(root, base)
+
+		if name := entry.first("name"); name != "" {
+			props["name"] = []any{name}
+		}
+		if published := entry.first("published"); published != "" {
+			props["published"] = []any{published}
+		}
+		if content := entry.first("content"); content != "" {
+			// Only text is kept: third-party HTML is not safe to republish without sanitizing.
+			props["content"] = []any{util.HtmlToText(content, contentWords)}
+		}
+		if author := authorOf(entry); author != nil {
+			props["author"] = []any{author}
+		}
+	}
+
+	if _, ok := props["name"]; !ok {
+		if title := pageTitle(page); title != "" {
+			props["name"] = []any{title}
+		}
+	}
+
+	return map[string]any{"type": []any{"h-cite"}, "properties": props}, nil
+}
+
+// authorOf reduces an entry's author to an h-card with name, url and photo, or a plain name.
+func authorOf(entry *item) any {
+	for _, v := range entry.Properties["author"] {
+		switch x := v.(type) {
+		case string:
+			if x != "" {
+				return x
+			}
+		case map[string]any:
+			props, _ := x["properties"].(map[string]any)
+			card := map[string]any{}
+			for _, key := range []string{"name", "url", "photo"} {
+				if values, ok := props[key].([]any); ok && len(values) > 0 {
+					if s, ok := values[0].(string); ok && s != "" {
+						card[key] = []any{s}
+					}
+				}
+			}
+			if len(card) > 0 {
+				return map[string]any{"type": []any{"h-card"}, "properties": card}
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchHtml downloads and parses an HTML page, enforcing the domain lists and the size limit. The
+// returned URL is the final one after redirects, for resolving relative links.
+func (e *Enricher) fetchHtml(ctx context.Context, target string) (*html.Node, *url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, nil, fmt.Errorf("not an http(s) url: %q", target)
+	}
+
+	if !e.DomainAllowed(u.Hostname()) {
+		return nil, nil, ErrDomainNotAllowed
+	}
+
+	resp, err := fetch.Get(ctx, e.client, target, "text/html, application/xhtml+xml;q=0.9")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Redirects may lead elsewhere; the final host must be allowed too.
+	if !e.DomainAllowed(resp.Request.URL.Hostname()) {
+		return nil, nil, ErrDomainNotAllowed
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, fmt.Errorf("responded with status %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	body, err := fetch.ReadLimited(resp.Body, e.maxBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return doc, resp.Request.URL, nil
+}
+
+// DomainAllowed applies the deny list, then the allow list when one is configured. Entries match the
+// host itself and any of its subdomains.
+func (e *Enricher) DomainAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	matches := func(domain string) bool {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+
+	if slices.ContainsFunc(e.cfg.DenyDomains, matches) {
+		return false
+	}
+
+	return len(e.cfg.AllowDomains) == 0 || slices.ContainsFunc(e.cfg.AllowDomains, matches)
+}
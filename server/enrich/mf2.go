@@ -0,0 +1,294 @@
+package enrich
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// item is a parsed microformats2 object. This is a deliberately small parser covering what reply
+// contexts need: explicit p-, u-, dt- and e- properties and nested h-* objects, with implied name
+// and url only for nested objects (enough for the common <a class="p-author h-card"> pattern), and
+// no backcompat classes.
+type item struct {
+	Type       []string
+	Properties map[string][]any
+}
+
+// findItem returns the first element in document order whose classes include one of the root types.
+func findItem(n *html.Node, types ...string) *html.Node {
+	if n.Type == html.ElementNode && slices.ContainsFunc(classes(n), func(c string) bool {
+		return slices.Contains(types, c)
+	}) {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findItem(c, types...); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func parseItem(n *html.Node, base *url.URL) *item {
+	it := &item{Type: rootTypes(n), Properties: map[string][]any{}}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkProperties(c, it, base)
+	}
+
+	return it
+}
+
+func walkProperties(n *html.Node, it *item, base *url.URL) {
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	props := classes(n)
+
+	// A nested object is the value of any properties on its root element, and its descendants
+	// belong to it rather than to the outer item.
+	if types := rootTypes(n); len(types) > 0 {
+		nested := parseItem(n, base)
+		nested.imply(n, base)
+		for _, prop := range props {
+			if name, ok := propertyName(prop); ok {
+				it.Properties[name] = append(it.Properties[name], nested.toMap())
+			}
+		}
+		return
+	}
+
+	for _, prop := range props {
+		name, ok := propertyName(prop)
+		if !ok {
+			continue
+		}
+
+		var value any
+		switch {
+		case strings.HasPrefix(prop, "p-"):
+			value = plainValue(n)
+		case strings.HasPrefix(prop, "u-"):
+			value = urlValue(n, base)
+		case strings.HasPrefix(prop, "dt-"):
+			value = dateValue(n)
+		case strings.HasPrefix(prop, "e-"):
+			value = map[string]any{"html": innerHtml(n), "value": textContent(n)}
+		}
+
+		it.Properties[name] = append(it.Properties[name], value)
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkProperties(c, it, base)
+	}
+}
+
+// imply fills in a missing name from the element's text (or image alt) and a missing url from a link
+// root element.
+func (it *item) imply(n *html.Node, base *url.URL) {
+	if _, ok := it.Properties["name"]; !ok {
+		if name := plainValue(n); name != "" {
+			it.Properties["name"] = []any{name}
+		}
+	}
+
+	if _, ok := it.Properties["url"]; !ok && (n.Data == "a" || n.Data == "area") {
+		if _, hasHref := attr(n, "href"); hasHref {
+			it.Properties["url"] = []any{urlValue(n, base)}
+		}
+	}
+}
+
+func (it *item) toMap() map[string]any {
+	types := make([]any, len(it.Type))
+	for i, t := range it.Type {
+		types[i] = t
+	}
+
+	props := make(map[string]any, len(it.Properties))
+	for k, v := range it.Properties {
+		props[k] = v
+	}
+
+	return map[string]any{"type": types, "properties": props}
+}
+
+// first returns the first string value of a property, or of the value of a nested object.
+func (it *item) first(name string) string {
+	for _, v := range it.Properties[name] {
+		switch x := v.(type) {
+		case string:
+			if x != "" {
+				return x
+			}
+		case map[string]any:
+			if s, ok := x["value"].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
+
+func classes(n *html.Node) []string {
+	for _, attr := range n.Attr {
+		if attr.Key == "class" {
+			return strings.Fields(attr.Val)
+		}
+	}
+
+	return nil
+}
+
+func rootTypes(n *html.Node) []string {
+	var types []string
+	for _, c := range classes(n) {
+		if strings.HasPrefix(c, "h-") && len(c) > 2 {
+			types = append(types, c)
+		}
+	}
+
+	return types
+}
+
+func propertyName(class string) (string, bool) {
+	for _, prefix := range []string{"p-", "u-", "dt-", "e-"} {
+		if name, ok := strings.CutPrefix(class, prefix); ok && name != "" {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+
+	return "", false
+}
+
+func plainValue(n *html.Node) string {
+	switch n.Data {
+	case "abbr", "link":
+		if v, ok := attr(n, "title"); ok {
+			return v
+		}
+	case "data", "input":
+		if v, ok := attr(n, "value"); ok {
+			return v
+		}
+	case "img", "area":
+		if v, ok := attr(n, "alt"); ok {
+			return v
+		}
+	}
+
+	return textContent(n)
+}
+
+func urlValue(n *html.Node, base *url.URL) string {
+	var raw string
+	var ok bool
+	switch n.Data {
+	case "a", "area", "link":
+		raw, ok = attr(n, "href")
+	case "img", "audio", "video", "source", "iframe":
+		raw, ok = attr(n, "src")
+	case "object":
+		raw, ok = attr(n, "data")
+	}
+
+	if !ok {
+		return strings.TrimSpace(textContent(n))
+	}
+
+	ref, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return raw
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
+func dateValue(n *html.Node) string {
+	switch n.Data {
+	case "time", "ins", "del":
+		if v, ok := attr(n, "datetime"); ok {
+			return v
+		}
+	case "abbr":
+		if v, ok := attr(n, "title"); ok {
+			return v
+		}
+	case "data", "input":
+		if v, ok := attr(n, "value"); ok {
+			return v
+		}
+	}
+
+	return textContent(n)
+}
+
+// textContent returns the element's text with whitespace collapsed, skipping scripts and styles.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			return
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func innerHtml(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		_ = html.Render(&b, c)
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// pageTitle returns the text of the document's <title> element.
+func pageTitle(doc *html.Node) string {
+	if title := findElement(doc, "title"); title != nil {
+		return textContent(title)
+	}
+
+	return ""
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package enrich
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+
+	"github.com/indieinfra/scribble/server/fetch"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+const defaultMaxImageBytes = 5 << 20
+
+// defaultPreviewProperties are the properties whose bare URLs are expanded into link previews.
+var defaultPreviewProperties = []string{"bookmark-of"}
+
+// linkPreview fetches target and describes it as an h-cite from its OpenGraph and Twitter card
+// metadata: name, summary, photo and publication (the site name). The page title and meta
+// description are used when no card metadata is present.
+func (e *Enricher) linkPreview(ctx context.Context, target string) (map[string]any, error) {
+	page, base, err := e.fetchHtml(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := metaTags(page)
+	pick := func(keys ...string) string {
+		for _, key := range keys {
+			if v := strings.TrimSpace(meta[key]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	props := map[string]any{"url": []any{target}}
+
+	name := pick("og:title", "twitter:title")
+	if name == "" {
+		name = pageTitle(page)
+	}
+	if name != "" {
+		props["name"] = []any{name}
+	}
+
+	if summary := pick("og:description", "twitter:description", "description"); summary != "" {
+		props["summary"] = []any{summary}
+	}
+
+	if site := pick("og:site_name", "application-name"); site != "" {
+		props["publication"] = []any{site}
+	}
+
+	if image := pick("og:image:secure_url", "og:image:url", "og:image", "twitter:image", "twitter:image:src"); image != "" {
+		if ref, err := url.Parse(image); err == nil {
+			photo := base.ResolveReference(ref).String()
+			if e.cfg.LinkPreview.ArchiveImage && e.media != nil {
+				if archived, err := e.archiveImage(ctx, photo); err != nil {
+					slog.WarnContext(ctx, "could not archive preview image", "url", photo, "error", err)
+				} else {
+					photo = archived
+				}
+			}
+			props["photo"] = []any{photo}
+		}
+	}
+
+	return map[string]any{"type": []any{"h-cite"}, "properties": props}, nil
+}
+
+// archiveImage copies a preview image into the site's media store so the preview survives the
+// original disappearing.
+func (e *Enricher) archiveImage(ctx context.Context, imageUrl string) (string, error) {
+	u, err := url.Parse(imageUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("not an http(s) url: %q", imageUrl)
+	}
+
+	if !e.DomainAllowed(u.Hostname()) {
+		return "", ErrDomainNotAllowed
+	}
+
+	resp, err := fetch.Get(ctx, e.client, imageUrl, "image/*")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("responded with status %d", resp.StatusCode)
+	}
+
+	maxBytes := e.cfg.LinkPreview.MaxImageBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxImageBytes
+	}
+
+	data, err := fetch.ReadLimited(resp.Body, maxBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// Trust the bytes rather than the remote server's Content-Type header.
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("not an image: %s", contentType)
+	}
+
+	sum := sha256.Sum256(data)
+	key, err := e.mediaPattern.GenerateFile(uuid.New().String(), hex.EncodeToString(sum[:]), util.FileExtension(contentType, u.Path))
+	if err != nil {
+		return "", err
+	}
+
+	// With content-addressed keys the same image may already be archived.
+	if e.mediaPattern.UsesHash() {
+		if info, err := e.media.Stat(ctx, key); err == nil {
+			return info.Url, nil
+		}
+	}
+
+	return media.UploadBytes(ctx, e.media, data, contentType, key)
+}
+
+// metaTags collects <meta> values keyed by their property or name attribute, lowercased. The first
+// occurrence of each key wins.
+func metaTags(doc *html.Node) map[string]string {
+	tags := make(map[string]string)
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "meta":
+				key, ok := attr(n, "property")
+				if !ok {
+					key, ok = attr(n, "name")
+				}
+				content, hasContent := attr(n, "content")
+				key = strings.ToLower(strings.TrimSpace(key))
+				if ok && hasContent && key != "" {
+					if _, seen := tags[key]; !seen {
+						tags[key] = content
+					}
+				}
+			case "body":
+				// Metadata belongs in the head; don't scan the whole page.
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	walk(doc)
+	return tags
+}
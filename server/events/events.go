@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/indieinfra/scribble/server/util"
+)
+
+// Type identifies a content lifecycle event.
+type Type string
+
+const (
+	PostCreated   Type = "post.created"
+	PostUpdated   Type = "post.updated"
+	PostDeleted   Type = "post.deleted"
+	PostUndeleted Type = "post.undeleted"
+	MediaUploaded Type = "media.uploaded"
+	MediaDeleted  Type = "media.deleted"
+)
+
+// Event describes a change to a site's content. Document is the post as stored, when known; it is
+// nil for deletions and media events.
+type Event struct {
+	Id       string            `json:"id"`
+	Type     Type              `json:"type"`
+	Me       string            `json:"me"`
+	Url      string            `json:"url"`
+	ClientId string            `json:"client_id,omitempty"`
+	Document *util.Mf2Document `json:"document,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// Subscriber receives events published on a bus. Handle is called synchronously from the request
+// that caused the event, so it must hand slow work (network calls, builds) off to the background.
+type Subscriber interface {
+	Handle(ctx context.Context, ev Event)
+}
+
+// Closer is implemented by subscribers holding background workers that must be stopped on shutdown.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// SubscriberFunc adapts a function to the Subscriber interface.
+type SubscriberFunc func(ctx context.Context, ev Event)
+
+func (f SubscriberFunc) Handle(ctx context.Context, ev Event) {
+	f(ctx, ev)
+}
+
+// Bus fans events out to every subscriber for a single site. A nil *Bus discards all events.
+type Bus struct {
+	me          string
+	mu          sync.RWMutex
+	subscribers []Subscriber
+}
+
+func NewBus(me string) *Bus {
+	return &Bus{me: me}
+}
+
+// Subscribe registers a subscriber for every subsequent event.
+func (b *Bus) Subscribe(s Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, s)
+}
+
+// Active reports whether anything is listening, letting callers skip work needed only to build events.
+func (b *Bus) Active() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subscribers) > 0
+}
+
+// Publish fills in the event's ID, site and time, then passes it to each subscriber. The context is
+// detached from the request's cancellation so background work outlives the response.
+func (b *Bus) Publish(ctx context.Context, ev Event) {
+	if !b.Active() {
+		return
+	}
+
+	ev.Id = uuid.NewString()
+	ev.Me = b.me
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	slog.DebugContext(ctx, "publishing event", "event", ev.Type, "url", ev.Url, "subscribers", len(subscribers))
+
+	ctx = context.WithoutCancel(ctx)
+	for _, s := range subscribers {
+		s.Handle(ctx, ev)
+	}
+}
+
+// Close stops every subscriber that holds background workers.
+func (b *Bus) Close(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	var firstErr error
+	for _, s := range subscribers {
+		if c, ok := s.(Closer); ok {
+			if err := c.Close(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,105 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/indieinfra/scribble/server/tracing"
+	"github.com/indieinfra/scribble/server/version"
+)
+
+// ErrPrivateAddress is returned when a request would connect to a loopback, private or otherwise
+// non-public address while private addresses are not allowed.
+var ErrPrivateAddress = errors.New("refusing to connect to a non-public address")
+
+// ErrTooLarge is returned by ReadLimited when a body exceeds the allowed size.
+var ErrTooLarge = errors.New("response body too large")
+
+// Options configures a client for fetching third-party URLs.
+type Options struct {
+	Timeout time.Duration
+	// AllowPrivate permits connections to loopback, private and link-local addresses. Leave it off
+	// in production: the URLs fetched come from posts and the pages they link to.
+	AllowPrivate bool
+}
+
+// UserAgent identifies Scribble to the sites it fetches from.
+func UserAgent() string {
+	return "Scribble/" + version.Version + " (+https://github.com/indieinfra/scribble)"
+}
+
+// NewClient returns an HTTP client for fetching URLs supplied by users or third parties. Unless
+// AllowPrivate is set, it refuses to connect to non-public addresses, checked after DNS resolution so
+// redirects and rebinding cannot bypass it.
+func NewClient(opts Options) *http.Client {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !IsPublic(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: tracing.Transport(transport),
+	}
+}
+
+// IsPublic reports whether ip is a globally routable unicast address.
+func IsPublic(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+// Get performs a GET request with Scribble's user agent and the given Accept header.
+func Get(ctx context.Context, client *http.Client, url string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent())
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	return client.Do(req)
+}
+
+// ReadLimited reads at most limit bytes from r, returning ErrTooLarge if there is more.
+func ReadLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+
+	return data, nil
+}
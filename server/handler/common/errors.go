@@ -3,26 +3,32 @@ package common
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
+	"github.com/indieinfra/scribble/server/imaging"
+	"github.com/indieinfra/scribble/server/mediaindex"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/util"
 	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
 )
 
 // LogAndWriteError logs an error with request context and maps known conditions to client responses.
 func LogAndWriteError(w http.ResponseWriter, r *http.Request, op string, err error) {
-	rl := util.FromContext(r.Context())
-	if rl == nil {
-		rl = util.WithRequest(log.Default(), r, "")
-	}
-	rl.Errorf("micropub %s failed: %v", op, err)
+	slog.ErrorContext(r.Context(), fmt.Sprintf("micropub %s failed", op), "error", err)
 
 	// Map known errors to user-friendly responses.
 	switch {
-	case errors.Is(err, content.ErrNotFound):
+	case errors.Is(err, content.ErrNotFound), errors.Is(err, media.ErrNotFound):
 		resp.WriteNotFound(w, "not found")
+	case errors.Is(err, imaging.ErrMalformed), errors.Is(err, ErrRejectedUpload), errors.Is(err, util.ErrFieldsTooLarge):
+		resp.WriteInvalidRequest(w, err.Error())
+	case errors.As(err, new(*http.MaxBytesError)):
+		resp.WriteInvalidRequest(w, "request body too large")
+	case errors.Is(err, mediaindex.ErrImporting):
+		w.Header().Set("Retry-After", "30")
+		resp.WriteServiceUnavailable(w, resp.ErrorResponse{Error: "temporarily_unavailable", Description: "Existing media is still being indexed; try again shortly"})
 	default:
 		resp.WriteInternalServerError(w, fmt.Sprintf("%s failed", op))
 	}
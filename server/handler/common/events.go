@@ -0,0 +1,26 @@
+package common
+
+import (
+	"net/http"
+
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/util"
+)
+
+// PublishEvent announces a content change on the request's site, attributing it to the client that
+// made the request.
+func PublishEvent(r *http.Request, typ events.Type, url string, doc *util.Mf2Document) {
+	site := state.GetSite(r.Context())
+	if site == nil {
+		return
+	}
+
+	ev := events.Event{Type: typ, Url: url, Document: doc}
+	if token := auth.GetToken(r.Context()); token != nil {
+		ev.ClientId = token.ClientId
+	}
+
+	site.Events.Publish(r.Context(), ev)
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
+)
+
+// RequireScope checks the request's token against the site's scope policy, writing an
+// insufficient_scope error and returning false when the scope is not granted.
+func RequireScope(w http.ResponseWriter, r *http.Request, scope auth.Scope) bool {
+	token := auth.GetToken(r.Context())
+	site := state.GetSite(r.Context())
+
+	if site == nil || !site.ScopePolicy.Granted(token, scope) {
+		resp.WriteInsufficientScope(w, fmt.Sprintf("no %s scope", scope.String()))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,358 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/imaging"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+// StoreUpload stores a file received from a client in the site's media store, generates any
+// configured image variants beside it and records it in the media index. Unless the site keeps
+// metadata, images are stripped of EXIF, XMP and IPTC data first. When the media path pattern is
+// content-addressed, a file that is already stored is not uploaded again. It returns the public URL
+// of the original file.
+//
+// The file is read from r once, front to back, so it may be streamed straight from the request; size
+// is its length, or -1 if that is not known. Only images that need processing are held in memory.
+// Other files are spooled to a temporary file when the pattern is content-addressed, since their key
+// depends on a hash of the whole file, and when audio or video is to be analyzed.
+func StoreUpload(ctx context.Context, site *state.Site, limits *config.ServerLimits, r io.Reader, size int64) (string, error) {
+	src := bufio.NewReaderSize(r, util.SniffLen)
+	head, err := src.Peek(util.SniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read upload: %w", err)
+	}
+
+	// The store labels the object with the type found, never the one the client claimed.
+	contentType := util.DetectContentType(head)
+	limit, err := checkUpload(site, limits, contentType, size)
+	if err != nil {
+		return "", err
+	}
+
+	// A streamed file's size is only known once it has been read, so it is enforced while reading.
+	body := &sizeLimitReader{r: src, limit: limit, contentType: contentType}
+	isImage := strings.HasPrefix(contentType, "image/")
+
+	// Images are buffered so their metadata can be removed and their variants rendered; anything else
+	// is streamed to the store as it is.
+	var data []byte
+	if isImage && (!site.Media.KeepMetadata || site.Images != nil) {
+		if data, err = io.ReadAll(body); err != nil {
+			return "", body.rejection(fmt.Errorf("read upload: %w", err))
+		}
+
+		if !site.Media.KeepMetadata {
+			if data, err = imaging.StripMetadata(data); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// Audio and video are analyzed from a copy on disk.
+	analyze := site.Analyzer != nil && (strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "video/"))
+
+	// The hash covers what is stored, after metadata removal.
+	var hash string
+	var spool *os.File
+	switch {
+	case data != nil:
+		if site.MediaPathPattern.UsesHash() {
+			sum := sha256.Sum256(data)
+			hash = hex.EncodeToString(sum[:])
+		}
+	case site.MediaPathPattern.UsesHash() || analyze:
+		var sum string
+		var cleanup func()
+		if spool, sum, cleanup, err = spoolUpload(r, body, size); err != nil {
+			return "", body.rejection(fmt.Errorf("spool upload: %w", err))
+		}
+		defer cleanup()
+
+		if site.MediaPathPattern.UsesHash() {
+			hash = sum
+		}
+	}
+
+	ext := util.FileExtension(contentType, "")
+	key, err := site.MediaPathPattern.GenerateFile(uuid.New().String(), hash, ext)
+	if err != nil {
+		return "", fmt.Errorf("generate path from pattern: %w", err)
+	}
+
+	if hash != "" {
+		info, err := site.MediaStore.Stat(ctx, key)
+		switch {
+		case err == nil:
+			slog.DebugContext(ctx, "media already stored, skipping upload", "url", info.Url)
+			recordExisting(ctx, site, info, hash)
+			return info.Url, nil
+		case !errors.Is(err, media.ErrNotFound):
+			return "", fmt.Errorf("stat media: %w", err)
+		}
+	}
+
+	var url string
+	switch {
+	case data != nil:
+		url, err = media.UploadBytes(ctx, site.MediaStore, data, contentType, key)
+	case spool != nil:
+		url, err = site.MediaStore.Upload(ctx, spool, body.n, contentType, key)
+	default:
+		url, err = site.MediaStore.Upload(ctx, body, size, contentType, key)
+	}
+	if err != nil {
+		return "", body.rejection(fmt.Errorf("upload media: %w", err))
+	}
+
+	entry := mediaindex.Entry{
+		Url:        url,
+		Key:        key,
+		Hash:       hash,
+		MimeType:   contentType,
+		Size:       body.n,
+		UploadedAt: time.Now().UTC(),
+	}
+	if data != nil {
+		entry.Size = int64(len(data))
+	}
+	if token := auth.GetToken(ctx); token != nil {
+		entry.ClientId = token.ClientId
+	}
+
+	if site.Images != nil && data != nil {
+		// Variants are a nicety: the original is already stored, so failures are only logged.
+		variants, err := storeVariants(ctx, site, data, key)
+		if err != nil {
+			slog.WarnContext(ctx, "could not generate image variants", "url", url, "error", err)
+		}
+		entry.Variants = variants
+	}
+
+	if analyze {
+		// Like variants, details and posters are a nicety, so failures are only logged.
+		if err := analyzeUpload(ctx, site, spool.Name(), contentType, key, &entry); err != nil {
+			slog.WarnContext(ctx, "could not analyze media", "url", url, "error", err)
+		}
+	}
+
+	if site.MediaIndex != nil {
+		if err := site.MediaIndex.Put(entry); err != nil {
+			slog.ErrorContext(ctx, "could not record media in index", "url", url, "error", err)
+		}
+	}
+
+	return url, nil
+}
+
+// analyzeUpload records the duration, codecs and dimensions of an audio or video file in its index
+// entry, and stores the video's poster frame beside it as "<key without extension>-poster.jpg".
+func analyzeUpload(ctx context.Context, site *state.Site, file string, contentType string, key string, entry *mediaindex.Entry) error {
+	info, err := site.Analyzer.Analyze(ctx, file, contentType)
+	if info != nil {
+		entry.Duration = info.Duration
+		entry.VideoCodec = info.VideoCodec
+		entry.AudioCodec = info.AudioCodec
+		entry.Width = info.Width
+		entry.Height = info.Height
+	}
+	if err != nil || len(info.Poster) == 0 {
+		return err
+	}
+
+	posterKey := strings.TrimSuffix(key, path.Ext(key)) + "-poster.jpg"
+	url, err := media.UploadBytes(ctx, site.MediaStore, info.Poster, "image/jpeg", posterKey)
+	if err != nil {
+		return fmt.Errorf("upload poster %s: %w", posterKey, err)
+	}
+	entry.Poster = url
+
+	return nil
+}
+
+// ErrRejectedUpload is returned for files whose type is not accepted or which are too large for
+// their type.
+var ErrRejectedUpload = errors.New("upload rejected")
+
+// defaultAllowedTypes applies when a site does not list the media types it accepts.
+var defaultAllowedTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/heic",
+	"video/mp4", "video/quicktime", "video/webm", "video/ogg",
+	"audio/mpeg", "audio/mp4", "audio/ogg", "audio/wave",
+}
+
+// CheckUploadType checks a file of the given sniffed media type and size against the site's allowed
+// types and the size limit for that type.
+func CheckUploadType(site *state.Site, limits *config.ServerLimits, contentType string, size int64) error {
+	_, err := checkUpload(site, limits, contentType, size)
+	return err
+}
+
+// checkUpload checks a file's media type against the site's allowed types and, when its size is
+// known, against the limit for that type. It returns the limit, zero meaning none.
+func checkUpload(site *state.Site, limits *config.ServerLimits, contentType string, size int64) (int64, error) {
+	allowed := site.Media.AllowedTypes
+	if len(allowed) == 0 {
+		allowed = defaultAllowedTypes
+	}
+	if !util.MatchMediaType(allowed, contentType) {
+		return 0, fmt.Errorf("%w: files of type %s are not accepted", ErrRejectedUpload, contentType)
+	}
+
+	limit := util.FileSizeLimit(limits, contentType)
+	if limit > 0 && size > limit {
+		return 0, tooLarge(contentType, limit)
+	}
+
+	return limit, nil
+}
+
+func tooLarge(contentType string, limit int64) error {
+	return fmt.Errorf("%w: %s files may be at most %d bytes", ErrRejectedUpload, contentType, limit)
+}
+
+// sizeLimitReader counts the bytes read through it and fails once there are more than limit, so an
+// oversized streamed file is abandoned part way instead of being stored. Zero means no limit.
+type sizeLimitReader struct {
+	r           io.Reader
+	n           int64
+	limit       int64
+	contentType string
+	exceeded    bool
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit > 0 && l.n > l.limit {
+		l.exceeded = true
+		return n, tooLarge(l.contentType, l.limit)
+	}
+
+	return n, err
+}
+
+// rejection returns the size limit error if the limit was exceeded, and err otherwise. Stores may
+// not wrap the errors of the readers they are given, so the limit is checked here.
+func (l *sizeLimitReader) rejection(err error) error {
+	if l.exceeded {
+		return tooLarge(l.contentType, l.limit)
+	}
+
+	return err
+}
+
+// recordExisting adds a deduplicated upload to the media index if it was stored before the index
+// knew about it. Entries already present, and their variants, are left as they are.
+func recordExisting(ctx context.Context, site *state.Site, info *media.ObjectInfo, hash string) {
+	if site.MediaIndex == nil {
+		return
+	}
+
+	if _, ok := site.MediaIndex.Get(info.Url); ok {
+		return
+	}
+
+	entry := mediaindex.Entry{
+		Url:        info.Url,
+		Key:        info.Key,
+		Hash:       hash,
+		MimeType:   info.ContentType,
+		Size:       info.Size,
+		UploadedAt: info.LastModified.UTC(),
+	}
+	if err := site.MediaIndex.Put(entry); err != nil {
+		slog.ErrorContext(ctx, "could not record media in index", "url", info.Url, "error", err)
+	}
+}
+
+// spoolUpload returns a file on disk holding the upload, positioned at the start, and the hex
+// SHA-256 of its contents. A file of known size already on disk, such as a finished resumable
+// upload, is used as it is; otherwise body is copied into a temporary file. cleanup releases the
+// spool.
+func spoolUpload(r io.Reader, body *sizeLimitReader, size int64) (*os.File, string, func(), error) {
+	h := sha256.New()
+
+	if staged, ok := r.(*os.File); ok && size >= 0 {
+		if _, err := staged.Seek(0, io.SeekStart); err != nil {
+			return nil, "", nil, err
+		}
+		if _, err := io.Copy(h, staged); err != nil {
+			return nil, "", nil, err
+		}
+		if _, err := staged.Seek(0, io.SeekStart); err != nil {
+			return nil, "", nil, err
+		}
+
+		body.n = size
+		return staged, hex.EncodeToString(h.Sum(nil)), func() {}, nil
+	}
+
+	spool, err := os.CreateTemp("", "scribble-upload-*")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	if _, err := io.Copy(io.MultiWriter(spool, h), body); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+
+	return spool, hex.EncodeToString(h.Sum(nil)), cleanup, nil
+}
+
+// storeVariants renders the configured variants of an uploaded image and stores each one beside it,
+// as "<key without extension>-<variant>.<ext>".
+func storeVariants(ctx context.Context, site *state.Site, data []byte, key string) ([]mediaindex.Variant, error) {
+	renditions, err := site.Images.Process(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(key, path.Ext(key))
+
+	var variants []mediaindex.Variant
+	for _, rendition := range renditions {
+		variantKey := fmt.Sprintf("%s-%s.%s", base, rendition.Name, rendition.Ext)
+		url, err := media.UploadBytes(ctx, site.MediaStore, rendition.Data, rendition.ContentType, variantKey)
+		if err != nil {
+			return variants, fmt.Errorf("upload variant %s: %w", variantKey, err)
+		}
+
+		variants = append(variants, mediaindex.Variant{
+			Name:   rendition.Name,
+			Url:    url,
+			Width:  rendition.Width,
+			Height: rendition.Height,
+			Type:   rendition.ContentType,
+		})
+	}
+
+	return variants, nil
+}
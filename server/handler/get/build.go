@@ -0,0 +1,22 @@
+package get
+
+import (
+	"net/http"
+
+	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
+)
+
+// HandleBuild reports the state of the site's build hook and the outcome of its last build.
+func HandleBuild(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+	site := state.GetSite(r.Context())
+	if site.Builder == nil {
+		resp.WriteInvalidRequest(w, "No build command is configured for this site")
+		return
+	}
+
+	resp.WriteOK(w, map[string]any{
+		"build": site.Builder.Status(),
+	})
+}
@@ -10,12 +10,14 @@ import (
 )
 
 func HandleCategory(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+	site := state.GetSite(r.Context())
+
 	page := p.GetIntOrDefault("page", 1)
 	if page < 1 {
 		page = 1
 	}
 
-	perPage := st.Cfg.Content.Pagination.PerPage
+	perPage := site.Content.Pagination.PerPage
 	limit := p.GetIntOrDefault("limit", perPage)
 	if limit < 1 || limit > perPage {
 		limit = perPage
@@ -23,7 +25,7 @@ func HandleCategory(st *state.ScribbleState, w http.ResponseWriter, r *http.Requ
 
 	filter := p.GetFirst("filter")
 
-	categories, err := st.ContentStore.ListCategories(r.Context(), page, limit, filter)
+	categories, err := site.ContentStore.ListCategories(r.Context(), page, limit, filter)
 	if err != nil {
 		common.LogAndWriteError(w, r, "list categories", err)
 		return
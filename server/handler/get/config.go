@@ -3,6 +3,7 @@ package get
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/indieinfra/scribble/server/body"
 	"github.com/indieinfra/scribble/server/resp"
@@ -27,8 +28,13 @@ type Config struct {
 }
 
 func HandleConfig(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+	publicUrl := state.GetSite(r.Context()).Micropub.PublicUrl
+	if publicUrl == "" {
+		publicUrl = st.Cfg.Server.PublicUrl
+	}
+
 	cfgOut := Config{
-		MediaEndpoint: fmt.Sprintf("%v/media", st.Cfg.Server.PublicUrl),
+		MediaEndpoint: fmt.Sprintf("%v/media", strings.TrimSuffix(publicUrl, "/")),
 		SyndicateTo:   []SyndicateTo{},
 	}
 
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/metrics"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
 )
@@ -14,6 +15,7 @@ func DispatchGet(st *state.ScribbleState) http.HandlerFunc {
 		"source":       HandleSource,
 		"category":     HandleCategory,
 		"syndicate-to": HandleSyndicateTo,
+		"build":        HandleBuild,
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -21,6 +23,7 @@ func DispatchGet(st *state.ScribbleState) http.HandlerFunc {
 		query := params.GetFirst("q")
 		if query != "" {
 			if handler, ok := handlers[query]; ok {
+				metrics.SetAction(r.Context(), "q="+query)
 				handler(st, w, r, params)
 				return
 			}
@@ -1,6 +1,9 @@
 package get
 
 import (
+	"errors"
+	"log/slog"
+	"maps"
 	"net/http"
 	"slices"
 
@@ -9,12 +12,15 @@ import (
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
 	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
 )
 
 func HandleSource(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+	site := state.GetSite(r.Context())
+
 	urlParam := p.Get("url")
 	if urlParam == nil {
-		handleMany(st, w, r, p)
+		handleMany(site, w, r, p)
 	} else {
 		url := urlParam.Value
 		if len(url) == 0 {
@@ -22,44 +28,51 @@ func HandleSource(st *state.ScribbleState, w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-		if !util.UrlIsSupported(st.Cfg.Content.PublicBaseUrl, url[0]) {
+		if !util.UrlIsSupported(site.Content.PublicBaseUrl, url[0]) {
 			resp.WriteInvalidRequest(w, "Invalid URL (not a supported destination)")
 			return
 		}
 
-		handleOne(st, w, r, p, url[0])
+		handleOne(site, w, r, p, url[0])
 	}
 }
 
-func handleMany(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+func handleMany(site *state.Site, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
 	page := p.GetIntOrDefault("page", 1)
 	if page < 1 {
 		page = 1
 	}
 
-	perPage := st.Cfg.Content.Pagination.PerPage
+	perPage := site.Content.Pagination.PerPage
 	limit := p.GetIntOrDefault("limit", perPage)
 	if limit < 1 || limit > perPage {
 		limit = perPage
 	}
 
-	docs, err := st.ContentStore.List(r.Context(), page, limit)
-	if err != nil {
+	docs, err := site.ContentStore.List(r.Context(), page, limit)
+	if errors.Is(err, content.ErrUnreadable) {
+		// Listing what could be read serves clients better than failing the whole page.
+		slog.WarnContext(r.Context(), "listing skipped unreadable documents", "page", page, "error", err)
+	} else if err != nil {
 		common.LogAndWriteError(w, r, "list content", err)
 		return
 	}
 
+	for i := range docs {
+		docs[i] = withVariants(site, docs[i])
+	}
+
 	resp.WriteOK(w, filterDocs(docs, p.Get("properties")))
 }
 
-func handleOne(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, p body.QueryParams, url string) {
-	doc, err := st.ContentStore.Get(r.Context(), url)
+func handleOne(site *state.Site, w http.ResponseWriter, r *http.Request, p body.QueryParams, url string) {
+	doc, err := site.ContentStore.Get(r.Context(), url)
 	if err != nil {
 		common.LogAndWriteError(w, r, "get content", err)
 		return
 	}
 
-	resp.WriteOK(w, filterDoc(*doc, p.Get("properties")))
+	resp.WriteOK(w, filterDoc(withVariants(site, *doc), p.Get("properties")))
 }
 
 func filterDocs(docs []util.Mf2Document, properties *body.QueryParam) []any {
@@ -93,3 +106,41 @@ func filterDoc(doc util.Mf2Document, properties *body.QueryParam) any {
 
 	return outProps
 }
+
+// withVariants expands photo values that have generated image variants into objects carrying the
+// variants alongside the original URL, so clients can pick a size.
+func withVariants(site *state.Site, doc util.Mf2Document) util.Mf2Document {
+	photos, ok := doc.Properties["photo"]
+	if !ok || site.MediaIndex == nil {
+		return doc
+	}
+
+	out := make([]any, len(photos))
+	for i, photo := range photos {
+		out[i] = photo
+
+		var value string
+		var obj map[string]any
+		switch v := photo.(type) {
+		case string:
+			value, obj = v, map[string]any{"value": v}
+		case map[string]any:
+			value, _ = v["value"].(string)
+			obj = maps.Clone(v)
+		}
+
+		entry, ok := site.MediaIndex.Get(value)
+		if !ok || len(entry.Variants) == 0 {
+			continue
+		}
+
+		obj["variants"] = entry.Variants
+		out[i] = obj
+	}
+
+	props := maps.Clone(doc.Properties)
+	props["photo"] = out
+	doc.Properties = props
+
+	return doc
+}
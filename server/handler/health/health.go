@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/version"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+// checkTimeout bounds how long a single store check may take.
+const checkTimeout = 5 * time.Second
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// HandleHealthz reports that the process is alive and serving requests.
+func HandleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp.WriteOK(w, map[string]string{"status": "ok"})
+	}
+}
+
+// HandleReadyz checks the content and media stores of every site. Stores that do not implement a
+// health check are assumed ready. Each check is reported as "ok" or "fail", with the reason for a
+// failure logged rather than returned, since it may name hosts, buckets or credentials. Responds 503
+// when any check fails.
+func HandleReadyz(st *state.ScribbleState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := readiness{Status: "ready", Checks: map[string]string{}}
+
+		for _, site := range st.Sites.All() {
+			if checker, ok := site.ContentStore.(content.HealthChecker); ok {
+				result.record(r.Context(), fmt.Sprintf("%s content", site.Me()), runCheck(r.Context(), checker.HealthCheck))
+			}
+			if checker, ok := site.MediaStore.(media.HealthChecker); ok {
+				result.record(r.Context(), fmt.Sprintf("%s media", site.Me()), runCheck(r.Context(), checker.HealthCheck))
+			}
+		}
+
+		if result.Status != "ready" {
+			resp.WriteServiceUnavailable(w, result)
+			return
+		}
+
+		resp.WriteOK(w, result)
+	}
+}
+
+// HandleVersion reports build information.
+func HandleVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp.WriteOK(w, version.Get())
+	}
+}
+
+func (rd *readiness) record(ctx context.Context, name string, err error) {
+	if err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "check", name, "error", err)
+		rd.Status = "unavailable"
+		rd.Checks[name] = "fail"
+		return
+	}
+
+	rd.Checks[name] = "ok"
+}
+
+func runCheck(ctx context.Context, check func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	return check(ctx)
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+type checkedContent struct {
+	content.Store
+	err error
+}
+
+func (c *checkedContent) HealthCheck(context.Context) error {
+	return c.err
+}
+
+type checkedMedia struct {
+	media.Store
+	err error
+}
+
+func (m *checkedMedia) HealthCheck(context.Context) error {
+	return m.err
+}
+
+func TestHandleReadyzHidesFailureDetail(t *testing.T) {
+	detail := "dial tcp 10.0.0.5:5432: password authentication failed for user scribble"
+
+	st := &state.ScribbleState{Sites: state.NewSiteRegistry()}
+	st.Sites.Add(&state.Site{
+		Micropub:     &config.Micropub{MeUrl: "https://example.com/"},
+		ContentStore: &checkedContent{err: errors.New(detail)},
+		MediaStore:   &checkedMedia{},
+	})
+
+	rec := httptest.NewRecorder()
+	HandleReadyz(st).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.0.5") || strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("response leaks the failure: %s", rec.Body.String())
+	}
+
+	var got readiness
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"https://example.com/ content": "fail", "https://example.com/ media": "ok"}
+	if got.Status != "unavailable" || len(got.Checks) != len(want) {
+		t.Fatalf("readiness = %+v", got)
+	}
+	for name, status := range want {
+		if got.Checks[name] != status {
+			t.Errorf("%s = %q, want %q", name, got.Checks[name], status)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/util"
+)
+
+// altFields are the properties whose values may carry alt text. Alt text for each is sent as
+// mp-<property>-alt, one value per file in the order the property lists them.
+var altFields = []string{"photo", "video", "audio"}
+
+func altParam(field string) string {
+	return "mp-" + field + "-alt"
+}
+
+// addAltText gives media values their alt text as {value, alt} objects. Alt text sent with the post
+// takes precedence, then any already on the value, then what was recorded when the file was uploaded.
+// Empty entries in mp-<property>-alt leave a value as it is, so they can stand in for files without
+// alt text. It returns the alt text to record in the media index once the post is stored.
+func addAltText(site *state.Site, doc *util.Mf2Document) altText {
+	pending := altText{}
+	for _, field := range altFields {
+		values := doc.Properties[field]
+		alts := doc.Properties[altParam(field)]
+
+		for i, value := range values {
+			alt := altAt(alts, i)
+			if alt == "" {
+				alt = recordedAlt(site, value)
+			}
+			if alt != "" {
+				values[i] = withAlt(value, alt)
+			}
+		}
+
+		pending.collect(values)
+	}
+
+	return pending
+}
+
+// errAltWithoutMedia rejects mp-<property>-alt sent under add without the values it describes.
+var errAltWithoutMedia = errors.New("alt text can only be added along with the media it describes; use replace to change the alt text of existing media")
+
+// applyAltUpdates turns mp-<property>-alt replacements into replacements of the property itself,
+// restating the post's current values with the new alt text. An empty entry removes a value's alt
+// text. Under add, mp-<property>-alt describes the values added alongside it, in order, as it does
+// on create. Values replaced or added outright are given recorded alt text as they are on create.
+// It returns the alt text to record in the media index once the update is stored.
+func applyAltUpdates(ctx context.Context, site *state.Site, url string, replacements map[string][]any, additions map[string][]any) (altText, error) {
+	var doc *util.Mf2Document
+	pending := altText{}
+
+	for _, field := range altFields {
+		if alts, ok := additions[altParam(field)]; ok {
+			delete(additions, altParam(field))
+
+			values := additions[field]
+			if len(values) == 0 {
+				return nil, fmt.Errorf("%w (%s)", errAltWithoutMedia, altParam(field))
+			}
+			for i, value := range values {
+				if alt := altAt(alts, i); alt != "" {
+					values[i] = withAlt(value, alt)
+				}
+			}
+		}
+
+		for _, values := range [][]any{replacements[field], additions[field]} {
+			for i, value := range values {
+				if alt := recordedAlt(site, value); alt != "" {
+					values[i] = withAlt(value, alt)
+				}
+			}
+		}
+
+		if alts, ok := replacements[altParam(field)]; ok {
+			delete(replacements, altParam(field))
+
+			values, replaced := replacements[field]
+			if !replaced {
+				if doc == nil {
+					var err error
+					if doc, err = site.ContentStore.Get(ctx, url); err != nil {
+						return nil, err
+					}
+				}
+				values = slices.Clone(doc.Properties[field])
+			}
+
+			for i := range min(len(values), len(alts)) {
+				values[i] = withAlt(values[i], altAt(alts, i))
+			}
+
+			if len(values) > 0 {
+				replacements[field] = values
+			}
+		}
+
+		pending.collect(replacements[field])
+		pending.collect(additions[field])
+	}
+
+	return pending, nil
+}
+
+// withAlt returns a media value carrying alt, as an object, or as a plain URL if alt is empty and
+// nothing else is left on the object.
+func withAlt(value any, alt string) any {
+	var obj map[string]any
+	switch v := value.(type) {
+	case string:
+		obj = map[string]any{"value": v}
+	case map[string]any:
+		obj = maps.Clone(v)
+	default:
+		return value
+	}
+
+	if alt != "" {
+		obj["alt"] = alt
+		return obj
+	}
+
+	delete(obj, "alt")
+	if url, ok := obj["value"].(string); ok && len(obj) == 1 {
+		return url
+	}
+
+	return obj
+}
+
+// altOf returns the URL of a media value and its alt text, if any.
+func altOf(value any) (url string, alt string) {
+	switch v := value.(type) {
+	case string:
+		return v, ""
+	case map[string]any:
+		url, _ = v["value"].(string)
+		alt, _ = v["alt"].(string)
+		return url, alt
+	}
+
+	return "", ""
+}
+
+func altAt(alts []any, i int) string {
+	if i >= len(alts) {
+		return ""
+	}
+
+	alt, _ := alts[i].(string)
+	return alt
+}
+
+// recordedAlt returns the alt text recorded for an uploaded file, unless the value already has some.
+func recordedAlt(site *state.Site, value any) string {
+	url, alt := altOf(value)
+	if alt != "" || url == "" || site.MediaIndex == nil {
+		return ""
+	}
+
+	entry, ok := site.MediaIndex.Get(url)
+	if !ok {
+		return ""
+	}
+
+	return entry.Alt
+}
+
+// altText maps the URLs of media values to the alt text given for them.
+type altText map[string]string
+
+func (a altText) collect(values []any) {
+	for _, value := range values {
+		if url, alt := altOf(value); url != "" && alt != "" {
+			a[url] = alt
+		}
+	}
+}
+
+// recordAltText keeps the media index in step with alt text given for uploaded files, so it is
+// reported by the media endpoint's q=source and reused by later posts. It is called only once the
+// post is stored, so a rejected request leaves the index as it was.
+func recordAltText(ctx context.Context, site *state.Site, pending altText) {
+	if site.MediaIndex == nil {
+		return
+	}
+
+	for url, alt := range pending {
+		if entry, ok := site.MediaIndex.Get(url); !ok || entry.Alt == alt {
+			continue
+		}
+
+		if _, err := site.MediaIndex.Update(url, func(e *mediaindex.Entry) { e.Alt = alt }); err != nil {
+			slog.ErrorContext(ctx, "could not record alt text", "url", url, "error", err)
+		}
+	}
+}
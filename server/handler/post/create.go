@@ -1,65 +1,94 @@
 package post
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/indieinfra/scribble/server/auth"
 	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/events"
 	"github.com/indieinfra/scribble/server/handler/common"
+	"github.com/indieinfra/scribble/server/imaging"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
 	"github.com/indieinfra/scribble/server/util"
 	"github.com/indieinfra/scribble/storage/content"
 )
 
+// defaultMaxFiles is how many files a multipart create may carry when limits.max_files is unset.
+const defaultMaxFiles = 10
+
+// upload is a file stored while reading a multipart create, under the property it was sent as.
+type upload struct {
+	field string
+	url   string
+}
+
+// Create stores a new post. Files sent with a multipart create are streamed to the media store as
+// they arrive, so the access token and action must be sent before them.
 func Create(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, pb *body.ParsedBody) {
-	if !requireScope(w, r, auth.ScopeCreate) {
+	site := state.GetSite(r.Context())
+	token := auth.GetToken(r.Context())
+
+	// Tokens holding only the draft scope may still create posts, as long as they are drafts.
+	canDraft := site.ScopePolicy.Granted(token, auth.ScopeDraft)
+	if !canDraft && !common.RequireScope(w, r, auth.ScopeCreate) {
 		return
 	}
 
 	ct, _ := util.ExtractMediaType(w, r)
 
-	document, err := buildDocument(ct, pb.Data)
-	if err != nil {
-		resp.WriteInvalidRequest(w, err.Error())
-		return
+	data := pb.Data
+	var uploads []upload
+	var photoMetadata *imaging.Metadata
+	if pb.Uploads != nil {
+		var ok bool
+		if uploads, ok = storeUploads(st, w, r, ct, pb.Uploads, &photoMetadata); !ok {
+			return
+		}
+
+		// Fields may follow the files, so the post is built from all of them once they are read.
+		data = pb.Uploads.Values()
 	}
 
-	for _, pf := range pb.Files {
-		if pf.Header == nil || pf.File == nil {
-			continue
-		}
+	document, ok := buildAllowedDocument(w, r, ct, data, uploads)
+	if !ok {
+		return
+	}
 
-		fileId := uuid.New().String()
-		fileKey, err := st.MediaPathPattern.Generate(fileId)
-		if err != nil {
-			common.LogAndWriteError(w, r, "generate path from pattern", err)
-			return
+	if photoMetadata != nil {
+		if !document.HasProp("published") && !photoMetadata.Taken.IsZero() {
+			document.AddProp("published", photoMetadata.Taken.Format(time.RFC3339))
 		}
-
-		url, err := st.MediaStore.Upload(r.Context(), &pf.File, pf.Header, fileKey)
-		if err != nil {
-			common.LogAndWriteError(w, r, "upload media", err)
-			return
+		if geo := photoMetadata.GeoUri(); !document.HasProp("location") && geo != "" {
+			document.AddProp("location", geo)
 		}
+	}
 
-		document.Properties[pf.Field] = append(document.Properties[pf.Field], url)
+	alts := addAltText(site, &document)
+	addPosters(site, &document)
 
-		pf.File.Close()
+	if site.Enricher != nil {
+		site.Enricher.Enrich(r.Context(), &document)
 	}
 
-	slug, err := st.ContentPathPattern.Generate(deriveSuggestedSlug(&document))
+	slug, err := site.ContentPathPattern.Generate(deriveSuggestedSlug(&document))
 	if err != nil {
 		common.LogAndWriteError(w, r, "generate path from pattern", err)
 		return
 	}
 
-	slug, err = ensureUniqueSlug(r.Context(), st.ContentStore, slug)
+	slug, err = ensureUniqueSlug(r.Context(), site.ContentStore, slug)
 	if err != nil {
 		common.LogAndWriteError(w, r, "slug lookup", err)
 		return
@@ -75,12 +104,16 @@ func Create(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, pb
 		document.AddProp("updated-at", timeNow)
 	}
 
-	url, now, err := st.ContentStore.Create(r.Context(), document)
+	url, now, err := site.ContentStore.Create(r.Context(), document)
 	if err != nil {
 		common.LogAndWriteError(w, r, "create content", err)
 		return
 	}
 
+	recordAltText(r.Context(), site, alts)
+
+	common.PublishEvent(r, events.PostCreated, url, &document)
+
 	if now {
 		resp.WriteCreated(w, url)
 	} else {
@@ -88,16 +121,164 @@ func Create(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, pb
 	}
 }
 
+// buildAllowedDocument builds the post from the request's fields and the files stored so far, and
+// checks that the token may create it.
+func buildAllowedDocument(w http.ResponseWriter, r *http.Request, contentType string, data map[string]any, uploads []upload) (util.Mf2Document, bool) {
+	document, err := buildDocument(contentType, data)
+	if err != nil {
+		resp.WriteInvalidRequest(w, err.Error())
+		return util.Mf2Document{}, false
+	}
+
+	document = withUploads(document, uploads)
+	if !allowsNewPost(w, r, document) {
+		return util.Mf2Document{}, false
+	}
+
+	return document, true
+}
+
+// allowsNewPost checks a post against the token's scopes and the client's allowed post types and
+// channels.
+func allowsNewPost(w http.ResponseWriter, r *http.Request, document util.Mf2Document) bool {
+	site := state.GetSite(r.Context())
+	token := auth.GetToken(r.Context())
+
+	if !document.IsDraft() && !common.RequireScope(w, r, auth.ScopeCreate) {
+		return false
+	}
+
+	if postType := util.DiscoverPostType(document); !site.ScopePolicy.AllowsPostType(token, postType) {
+		resp.WriteForbidden(w, fmt.Sprintf("This client may not create %s posts", postType))
+		return false
+	}
+
+	if channel := extractStringFromProperty(document.Properties["mp-channel"]); !site.ScopePolicy.AllowsChannel(token, channel) {
+		resp.WriteForbidden(w, fmt.Sprintf("This client may not post to channel %q", channel))
+		return false
+	}
+
+	return true
+}
+
+// storeUploads streams each file of a multipart create into the media store. Before a file is stored,
+// the post is checked as it would be with that file, judged by the fields sent so far, so a client
+// cannot store files for a post it may not create. Files stored before a later one is refused are
+// left for media garbage collection (action=gc) to remove. A photo's capture time and location are
+// read into photoMetadata when the client asks for them with mp-photo-metadata.
+func storeUploads(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, contentType string, stream *util.MultipartStream, photoMetadata **imaging.Metadata) ([]upload, bool) {
+	site := state.GetSite(r.Context())
+	limits := &st.Cfg.Server.Limits
+
+	maxFiles := int(limits.MaxFiles)
+	if maxFiles == 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	var uploads []upload
+	for {
+		part, err := stream.NextFile()
+		if errors.Is(err, io.EOF) {
+			return uploads, true
+		}
+		if err != nil {
+			common.LogAndWriteError(w, r, "parse multipart", err)
+			return nil, false
+		}
+
+		if len(uploads) == maxFiles {
+			common.LogAndWriteError(w, r, "upload media", fmt.Errorf("%w: at most %d", util.ErrTooManyFiles, maxFiles))
+			return nil, false
+		}
+
+		// Uploaded files count towards the post type, so it is judged with this one in place, its name
+		// standing in for the URL it will be stored at.
+		field := strings.TrimSuffix(part.FormName(), "[]")
+		document, ok := buildAllowedDocument(w, r, contentType, stream.Values(), append(slices.Clip(uploads), upload{field, part.FileName()}))
+		if !ok {
+			return nil, false
+		}
+
+		var file io.Reader = part
+		if field == "photo" && *photoMetadata == nil && isTruthy(extractStringFromProperty(document.Properties["mp-photo-metadata"])) {
+			// The metadata is removed from the stored file, so it is read first. Images are held in
+			// memory while they are stored anyway.
+			data, err := io.ReadAll(io.LimitReader(part, util.LargestFileSize(limits)+1))
+			if err != nil {
+				common.LogAndWriteError(w, r, "parse multipart", err)
+				return nil, false
+			}
+			if *photoMetadata, err = imaging.ReadMetadata(data); err != nil {
+				slog.WarnContext(r.Context(), "could not read photo metadata", "error", err)
+			}
+			file = bytes.NewReader(data)
+		}
+
+		url, err := common.StoreUpload(r.Context(), site, limits, file, -1)
+		if err != nil {
+			common.LogAndWriteError(w, r, "upload media", err)
+			return nil, false
+		}
+
+		uploads = append(uploads, upload{field, url})
+	}
+}
+
+// withUploads returns a copy of doc with each upload's URL added to the property it was sent as.
+func withUploads(doc util.Mf2Document, uploads []upload) util.Mf2Document {
+	doc.Properties = maps.Clone(doc.Properties)
+	for _, u := range uploads {
+		doc.Properties[u.field] = append(slices.Clip(doc.Properties[u.field]), u.url)
+	}
+
+	return doc
+}
+
+// addPosters turns video values into objects carrying the poster frame captured when the video was
+// uploaded, unless the client gave a poster itself.
+func addPosters(site *state.Site, doc *util.Mf2Document) {
+	videos, ok := doc.Properties["video"]
+	if !ok || site.MediaIndex == nil {
+		return
+	}
+
+	for i, video := range videos {
+		var value string
+		var obj map[string]any
+		switch v := video.(type) {
+		case string:
+			value, obj = v, map[string]any{"value": v}
+		case map[string]any:
+			if _, ok := v["poster"]; ok {
+				continue
+			}
+			value, _ = v["value"].(string)
+			obj = maps.Clone(v)
+		default:
+			continue
+		}
+
+		entry, ok := site.MediaIndex.Get(value)
+		if !ok || entry.Poster == "" {
+			continue
+		}
+
+		obj["poster"] = entry.Poster
+		videos[i] = obj
+	}
+}
+
 func buildDocument(contentType string, data map[string]any) (util.Mf2Document, error) {
 	var doc util.Mf2Document
 
 	switch contentType {
 	case "application/json":
 		doc = normalizeJson(data)
-	case "multipart/form-data":
-	case "application/x-www-form-urlencoded":
+	case "multipart/form-data", "application/x-www-form-urlencoded":
 		doc = normalizeFormBody(data)
+		// Multipart fields sent after the files are only read with them, request parameters included.
 		delete(doc.Properties, "access_token")
+		delete(doc.Properties, "action")
 	default:
 		return util.Mf2Document{}, fmt.Errorf("unsupported content type %q", contentType)
 	}
@@ -272,6 +453,16 @@ func extractStringFromProperty(values []any) string {
 	return ""
 }
 
+// isTruthy interprets a form-style boolean flag.
+func isTruthy(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "1", "on", "yes":
+		return true
+	}
+
+	return false
+}
+
 // processMpProperties handles server command properties (mp-*) and removes them from the document.
 // Returns the suggested slug from mp-slug if present, otherwise returns empty string.
 func processMpProperties(doc *util.Mf2Document) string {
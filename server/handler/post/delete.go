@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/events"
 	"github.com/indieinfra/scribble/server/handler/common"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
@@ -11,6 +12,8 @@ import (
 )
 
 func Delete(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, data map[string]any, isUndelete bool) {
+	site := state.GetSite(r.Context())
+
 	urlRaw, ok := data["url"]
 	if !ok {
 		resp.WriteInvalidRequest(w, "URL to (un)delete must be specified")
@@ -23,29 +26,31 @@ func Delete(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, dat
 		return
 	}
 
-	if !util.UrlIsSupported(st.Cfg.Content.PublicBaseUrl, url) {
+	if !util.UrlIsSupported(site.Content.PublicBaseUrl, url) {
 		resp.WriteInvalidRequest(w, "Invalid URL (not a supported destination)")
 		return
 	}
 
 	if isUndelete {
-		if !requireScope(w, r, auth.ScopeUndelete) {
+		if !common.RequireScope(w, r, auth.ScopeUndelete) {
 			return
 		}
 
-		if _, err := st.ContentStore.Undelete(r.Context(), url); err != nil {
+		if _, err := site.ContentStore.Undelete(r.Context(), url); err != nil {
 			common.LogAndWriteError(w, r, "undelete content", err)
 		} else {
+			common.PublishEvent(r, events.PostUndeleted, url, nil)
 			resp.WriteNoContent(w)
 		}
 	} else {
-		if !requireScope(w, r, auth.ScopeDelete) {
+		if !common.RequireScope(w, r, auth.ScopeDelete) {
 			return
 		}
 
-		if _, err := st.ContentStore.Delete(r.Context(), url); err != nil {
+		if _, err := site.ContentStore.Delete(r.Context(), url); err != nil {
 			common.LogAndWriteError(w, r, "delete content", err)
 		} else {
+			common.PublishEvent(r, events.PostDeleted, url, nil)
 			resp.WriteNoContent(w)
 		}
 	}
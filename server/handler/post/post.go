@@ -2,17 +2,26 @@ package post
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 
 	"github.com/indieinfra/scribble/server/auth"
 	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/metrics"
 	"github.com/indieinfra/scribble/server/middleware"
+	"github.com/indieinfra/scribble/server/ratelimit"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
 )
 
+// actionBudgets maps each action to the rate limit budget it is charged against.
+var actionBudgets = map[string]ratelimit.Class{
+	"create":   ratelimit.ClassCreate,
+	"update":   ratelimit.ClassUpdate,
+	"delete":   ratelimit.ClassUpdate,
+	"undelete": ratelimit.ClassUpdate,
+}
+
 func DispatchPost(st *state.ScribbleState) http.HandlerFunc {
 	handlers := map[string]func(*state.ScribbleState, http.ResponseWriter, *http.Request, *body.ParsedBody){
 		"create": Create,
@@ -33,27 +42,13 @@ func DispatchPost(st *state.ScribbleState) http.HandlerFunc {
 			return
 		}
 		if parsed.AccessToken != "" && auth.GetToken(r.Context()) != nil {
-			for _, pf := range parsed.Files {
-				if pf.File != nil {
-					err := pf.File.Close()
-					if err != nil {
-						log.Printf("Error closing file: %v", err)
-					}
-				}
-			}
 			resp.WriteBadRequest(w, "access token must appear in header or body, not both")
 			return
 		}
-		r, ok = middleware.EnsureTokenForRequest(st.Cfg, w, r, parsed.AccessToken)
+		r, ok = middleware.EnsureTokenForRequest(st, w, r, parsed.AccessToken)
 		if !ok {
 			return
 		}
-		for _, pf := range parsed.Files {
-			if pf.File != nil {
-				defer pf.File.Close()
-			}
-		}
-
 		actionRaw, ok := parsed.Data["action"]
 		if !ok {
 			actionRaw = "create"
@@ -67,7 +62,14 @@ func DispatchPost(st *state.ScribbleState) http.HandlerFunc {
 
 		delete(parsed.Data, "action")
 
-		if handler, ok := handlers[strings.ToLower(action)]; ok {
+		action = strings.ToLower(action)
+		if handler, ok := handlers[action]; ok {
+			metrics.SetAction(r.Context(), action)
+
+			if !middleware.RequireBudget(st, w, r, actionBudgets[action]) {
+				return
+			}
+
 			handler(st, w, r, parsed)
 			return
 		}
@@ -75,11 +77,3 @@ func DispatchPost(st *state.ScribbleState) http.HandlerFunc {
 		resp.WriteInvalidRequest(w, fmt.Sprintf("Unknown action: %q", action))
 	}
 }
-
-func requireScope(w http.ResponseWriter, r *http.Request, scope auth.Scope) bool {
-	if !auth.RequestHasScope(r, scope) {
-		resp.WriteInsufficientScope(w, fmt.Sprintf("no %s scope", scope.String()))
-		return false
-	}
-	return true
-}
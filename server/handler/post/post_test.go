@@ -0,0 +1,191 @@
+package post
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+)
+
+type fakeStore struct {
+	content.Store
+	doc *util.Mf2Document
+}
+
+func (f fakeStore) Get(context.Context, string) (*util.Mf2Document, error) {
+	return f.doc, nil
+}
+
+func noteOnlySite(doc *util.Mf2Document) *state.Site {
+	policy := auth.NewScopePolicy(&config.ScopePolicy{Clients: []config.ClientPolicy{
+		{ClientId: "https://notes.example.com/", PostTypes: []string{"note"}},
+	}})
+
+	return &state.Site{ScopePolicy: policy, ContentStore: fakeStore{doc: doc}}
+}
+
+func noteOnlyRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	token := &auth.TokenDetails{ClientId: "https://notes.example.com/", Scope: "create update"}
+	return r.WithContext(auth.AddToken(r.Context(), token))
+}
+
+func TestWithUploadsCountsFilesTowardsPostType(t *testing.T) {
+	doc := util.Mf2Document{Type: []string{"h-entry"}, Properties: util.MicroformatProperties{
+		"content": {"hello"},
+	}}
+
+	if got := util.DiscoverPostType(withUploads(doc, []upload{{"audio", "memo.m4a"}})); got != "audio" {
+		t.Errorf("post type = %q, want audio", got)
+	}
+	if _, ok := doc.Properties["audio"]; ok {
+		t.Error("withUploads changed the original document")
+	}
+}
+
+func TestAllowsUpdatedPostType(t *testing.T) {
+	note := &util.Mf2Document{Type: []string{"h-entry"}, Properties: util.MicroformatProperties{
+		"content": {"hello"},
+	}}
+
+	tests := []struct {
+		name         string
+		replacements map[string][]any
+		additions    map[string][]any
+		allowed      bool
+	}{
+		{"edit content", map[string][]any{"content": {"hi"}}, nil, true},
+		{"add photo", nil, map[string][]any{"photo": {"https://media.example.com/a.jpg"}}, false},
+		{"replace video", map[string][]any{"video": {"https://media.example.com/a.mp4"}}, nil, false},
+		{"add audio", nil, map[string][]any{"audio": {"https://media.example.com/a.mp3"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			got := allowsUpdatedPostType(w, noteOnlyRequest(), noteOnlySite(note), "https://example.com/a", tt.replacements, tt.additions, nil)
+			if got != tt.allowed {
+				t.Errorf("allowed = %v, want %v", got, tt.allowed)
+			}
+			if !tt.allowed && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+
+	if len(note.Properties) != 1 {
+		t.Errorf("check changed the stored document: %v", note.Properties)
+	}
+}
+
+func TestApplyAltUpdatesUnderAdd(t *testing.T) {
+	photo := &util.Mf2Document{Type: []string{"h-entry"}, Properties: util.MicroformatProperties{
+		"photo": {"https://media.example.com/old.jpg"},
+	}}
+	site := &state.Site{ContentStore: fakeStore{doc: photo}}
+
+	additions := map[string][]any{
+		"photo":        {"https://media.example.com/a.jpg", "https://media.example.com/b.jpg"},
+		"mp-photo-alt": {"", "A bee"},
+	}
+	alts, err := applyAltUpdates(context.Background(), site, "https://example.com/a", map[string][]any{}, additions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := additions["mp-photo-alt"]; ok {
+		t.Error("mp-photo-alt would be stored as a property")
+	}
+	want := []any{"https://media.example.com/a.jpg", map[string]any{"value": "https://media.example.com/b.jpg", "alt": "A bee"}}
+	if fmt.Sprint(additions["photo"]) != fmt.Sprint(want) {
+		t.Errorf("photo = %v, want %v", additions["photo"], want)
+	}
+	if fmt.Sprint(alts) != fmt.Sprint(altText{"https://media.example.com/b.jpg": "A bee"}) {
+		t.Errorf("alt text to record = %v", alts)
+	}
+
+	orphaned := map[string][]any{"mp-photo-alt": {"A bee"}}
+	if _, err := applyAltUpdates(context.Background(), site, "https://example.com/a", map[string][]any{}, orphaned); !errors.Is(err, errAltWithoutMedia) {
+		t.Errorf("alt text without media: err = %v, want errAltWithoutMedia", err)
+	}
+}
+
+func TestRefusedUpdateLeavesAltTextUnrecorded(t *testing.T) {
+	index, err := mediaindex.Open("https://example.com/", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Put(mediaindex.Entry{Url: "https://media.example.com/a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	note := &util.Mf2Document{Type: []string{"h-entry"}, Properties: util.MicroformatProperties{
+		"content": {"hello"},
+	}}
+	site := noteOnlySite(note)
+	site.Content = &config.Content{PublicBaseUrl: "https://example.com/"}
+	site.MediaIndex = index
+
+	r := noteOnlyRequest()
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(state.AddSite(r.Context(), site))
+	w := httptest.NewRecorder()
+
+	Update(nil, w, r, map[string]any{
+		"url": "https://example.com/a",
+		"add": map[string]any{
+			"photo":        []any{"https://media.example.com/a.jpg"},
+			"mp-photo-alt": []any{"A bee"},
+		},
+	})
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+	if entry, _ := index.Get("https://media.example.com/a.jpg"); entry.Alt != "" {
+		t.Errorf("refused update recorded alt text %q", entry.Alt)
+	}
+}
+
+func TestCreateRefusesFileBeforeStoringIt(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("content", "hello")
+	part, _ := mw.CreateFormFile("photo", "a.jpg")
+	part.Write([]byte("not stored"))
+	mw.Close()
+
+	st := &state.ScribbleState{Cfg: &config.Config{}}
+	st.Cfg.Server.Limits = config.ServerLimits{MaxMultipartMem: 1 << 10, MaxFileSize: 1 << 10}
+
+	r := noteOnlyRequest()
+	r.Body = io.NopCloser(&buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r = r.WithContext(state.AddSite(r.Context(), noteOnlySite(nil)))
+	w := httptest.NewRecorder()
+
+	pb, ok := body.ReadBody(st.Cfg, w, r)
+	if !ok {
+		t.Fatalf("body not read: %d %s", w.Code, w.Body)
+	}
+
+	// The site has no media store, so storing the photo would panic.
+	Create(st, w, r, pb)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
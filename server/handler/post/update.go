@@ -1,21 +1,28 @@
 package post
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
 
 	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/events"
 	"github.com/indieinfra/scribble/server/handler/common"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
 	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
 )
 
 func Update(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, data map[string]any) {
-	if !requireScope(w, r, auth.ScopeUpdate) {
+	if !common.RequireScope(w, r, auth.ScopeUpdate) {
 		return
 	}
 
+	site := state.GetSite(r.Context())
+
 	ct, _ := util.ExtractMediaType(w, r)
 	if ct != "application/json" {
 		resp.WriteInvalidRequest(w, "Update may only be processed via JSON body")
@@ -28,7 +35,7 @@ func Update(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, dat
 		return
 	}
 
-	if !util.UrlIsSupported(st.Cfg.Content.PublicBaseUrl, url) {
+	if !util.UrlIsSupported(site.Content.PublicBaseUrl, url) {
 		resp.WriteInvalidRequest(w, "Invalid URL (not a supported destination)")
 		return
 	}
@@ -51,12 +58,35 @@ func Update(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, dat
 		return
 	}
 
-	newUrl, err := st.ContentStore.Update(r.Context(), url, replacements, additions, deletions)
+	alts, err := applyAltUpdates(r.Context(), site, url, replacements, additions)
+	if errors.Is(err, errAltWithoutMedia) {
+		resp.WriteInvalidRequest(w, err.Error())
+		return
+	} else if err != nil {
+		common.LogAndWriteError(w, r, "update alt text", err)
+		return
+	}
+
+	if !allowsUpdatedPostType(w, r, site, url, replacements, additions, deletions) {
+		return
+	}
+
+	newUrl, err := site.ContentStore.Update(r.Context(), url, replacements, additions, deletions)
 	if err != nil {
 		common.LogAndWriteError(w, r, "update content", err)
 		return
 	}
 
+	recordAltText(r.Context(), site, alts)
+
+	if site.Events.Active() {
+		doc, err := site.ContentStore.Get(r.Context(), newUrl)
+		if err != nil {
+			slog.WarnContext(r.Context(), "could not load updated document for event", "url", newUrl, "error", err)
+		}
+		common.PublishEvent(r, events.PostUpdated, newUrl, doc)
+	}
+
 	if newUrl != url {
 		resp.WriteCreated(w, newUrl)
 	} else {
@@ -64,6 +94,31 @@ func Update(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, dat
 	}
 }
 
+// allowsUpdatedPostType checks that a client restricted to some types of post cannot turn a post into
+// another type, such as by adding a photo to a note, judging the post as it will be after the update.
+func allowsUpdatedPostType(w http.ResponseWriter, r *http.Request, site *state.Site, url string, replacements map[string][]any, additions map[string][]any, deletions any) bool {
+	token := auth.GetToken(r.Context())
+	if !site.ScopePolicy.RestrictsPostTypes(token) {
+		return true
+	}
+
+	doc, err := site.ContentStore.Get(r.Context(), url)
+	if err != nil {
+		common.LogAndWriteError(w, r, "update content", err)
+		return false
+	}
+
+	updated := util.Mf2Document{Type: doc.Type, Properties: maps.Clone(doc.Properties)}
+	content.ApplyMutations(&updated, maps.Clone(replacements), additions, deletions)
+
+	if postType := util.DiscoverPostType(updated); !site.ScopePolicy.AllowsPostType(token, postType) {
+		resp.WriteForbidden(w, fmt.Sprintf("This client may not create %s posts", postType))
+		return false
+	}
+
+	return true
+}
+
 func getStringField(data map[string]any, key string) (string, error) {
 	raw, ok := data[key]
 	if !ok {
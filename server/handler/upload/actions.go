@@ -0,0 +1,160 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/handler/common"
+	"github.com/indieinfra/scribble/server/mediagc"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/metrics"
+	"github.com/indieinfra/scribble/server/middleware"
+	"github.com/indieinfra/scribble/server/ratelimit"
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/util"
+	storageutil "github.com/indieinfra/scribble/storage/util"
+)
+
+// DispatchMediaPost handles POST requests to the media endpoint: multipart bodies are uploads, while
+// form-encoded and JSON bodies carry an action (delete, or gc to collect orphaned media).
+func DispatchMediaPost(st *state.ScribbleState) http.HandlerFunc {
+	upload := HandleMediaUpload(st)
+	handlers := map[string]func(http.ResponseWriter, *http.Request, map[string]any){
+		"delete": handleMediaDelete,
+		"gc":     handleMediaGC,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, ok := util.ExtractMediaType(w, r)
+		if !ok {
+			return
+		}
+
+		if mediaType == "multipart/form-data" {
+			upload(w, r)
+			return
+		}
+
+		parsed, ok := body.ReadBody(st.Cfg, w, r)
+		if !ok {
+			return
+		}
+
+		if parsed.AccessToken != "" && auth.GetToken(r.Context()) != nil {
+			resp.WriteInvalidRequest(w, "access token must appear in header or body, not both")
+			return
+		}
+
+		r, ok = middleware.EnsureTokenForRequest(st, w, r, parsed.AccessToken)
+		if !ok {
+			return
+		}
+
+		action, _ := parsed.Data["action"].(string)
+		action = strings.ToLower(action)
+
+		handler, ok := handlers[action]
+		if !ok {
+			resp.WriteInvalidRequest(w, fmt.Sprintf("Unknown action: %q", action))
+			return
+		}
+
+		metrics.SetAction(r.Context(), "media "+action)
+
+		if !middleware.RequireBudget(st, w, r, ratelimit.ClassMedia) {
+			return
+		}
+
+		handler(w, r, parsed.Data)
+	}
+}
+
+// handleMediaDelete removes an uploaded file, and any variants generated from it, from the media
+// store. Files a post still refers to are kept, and the request refused.
+func handleMediaDelete(w http.ResponseWriter, r *http.Request, data map[string]any) {
+	if !common.RequireScope(w, r, auth.ScopeMedia) {
+		return
+	}
+
+	site := state.GetSite(r.Context())
+
+	url, ok := data["url"].(string)
+	if !ok || url == "" {
+		resp.WriteInvalidRequest(w, "URL to delete must be specified")
+		return
+	}
+
+	base := storageutil.NormalizeBaseURL(site.Media.PublicBaseUrl)
+	if !util.UrlIsSupported(base, url) {
+		resp.WriteInvalidRequest(w, "Invalid URL (not a supported destination)")
+		return
+	}
+
+	entry, known := site.MediaIndex.Get(url)
+	if !known {
+		if _, err := site.MediaStore.Stat(r.Context(), strings.TrimPrefix(url, base)); err != nil {
+			common.LogAndWriteError(w, r, "stat media", err)
+			return
+		}
+		entry = mediaindex.Entry{Url: url}
+	}
+
+	// Identical uploads share one object, so a file may be in use by posts other than the one its
+	// uploader has in mind.
+	referenced, err := mediagc.Referenced(r.Context(), site.Content, site.ContentStore, site.Media, entry)
+	if err != nil {
+		common.LogAndWriteError(w, r, "check media references", err)
+		return
+	}
+	if referenced {
+		resp.WriteConflict(w, "The file is still used by a post; remove it from the post first")
+		return
+	}
+
+	if err := site.MediaIndex.Delete(r.Context(), site.MediaStore, url); err != nil {
+		common.LogAndWriteError(w, r, "delete media", err)
+		return
+	}
+
+	common.PublishEvent(r, events.MediaDeleted, url, nil)
+	resp.WriteNoContent(w)
+}
+
+// handleMediaGC runs the site's media collector on demand and reports what it removed, or with
+// dry_run, what it would remove.
+func handleMediaGC(w http.ResponseWriter, r *http.Request, data map[string]any) {
+	if !common.RequireScope(w, r, auth.ScopeMedia) || !common.RequireScope(w, r, auth.ScopeDelete) {
+		return
+	}
+
+	site := state.GetSite(r.Context())
+	if site.MediaGC == nil {
+		resp.WriteInvalidRequest(w, "Media collection is not enabled for this site")
+		return
+	}
+
+	dryRun := false
+	switch v := data["dry_run"].(type) {
+	case bool:
+		dryRun = v
+	case string:
+		dryRun = strings.EqualFold(v, "true") || v == "1"
+	}
+
+	report, err := site.MediaGC.Run(r.Context(), dryRun)
+	if errors.Is(err, mediagc.ErrUnstableScan) {
+		resp.WriteConflict(w, "Posts changed during the collection; try again")
+		return
+	} else if err != nil {
+		common.LogAndWriteError(w, r, "collect media", err)
+		return
+	}
+
+	resp.WriteOK(w, report)
+}
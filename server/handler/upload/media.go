@@ -1,17 +1,24 @@
 package upload
 
 import (
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 
-	"github.com/google/uuid"
 	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/events"
 	"github.com/indieinfra/scribble/server/handler/common"
+	"github.com/indieinfra/scribble/server/mediaindex"
 	"github.com/indieinfra/scribble/server/middleware"
+	"github.com/indieinfra/scribble/server/ratelimit"
 	"github.com/indieinfra/scribble/server/resp"
 	"github.com/indieinfra/scribble/server/state"
 	"github.com/indieinfra/scribble/server/util"
 )
 
+// HandleMediaUpload stores the file sent in the "file" field as it arrives, without buffering the
+// request. Because of that, an access token sent in the body must come before the file.
 func HandleMediaUpload(st *state.ScribbleState) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, _, ok := util.RequireValidMediaContentType(w, r)
@@ -19,48 +26,82 @@ func HandleMediaUpload(st *state.ScribbleState) http.HandlerFunc {
 			return
 		}
 
-		maxMemory := int64(st.Cfg.Server.Limits.MaxMultipartMem)
-		maxSize := int64(st.Cfg.Server.Limits.MaxFileSize)
-		parsed, err := util.ParseMultipart(w, r, maxMemory, maxSize)
+		limits := &st.Cfg.Server.Limits
+		stream, err := util.NewMultipartStream(w, r, int64(limits.MaxMultipartMem), util.LargestFileSize(limits))
 		if err != nil {
 			common.LogAndWriteError(w, r, "parse multipart", err)
 			return
 		}
 
-		token := auth.PopAccessToken(parsed.Values)
-		if token != "" && auth.GetToken(r.Context()) != nil {
-			parsed.CloseFiles()
-			resp.WriteInvalidRequest(w, "access token must appear in header or body, not both")
-			return
-		}
+		authorized := false
+		authorize := func() bool {
+			authorized = true
 
-		r, ok = middleware.EnsureTokenForRequest(st.Cfg, w, r, token)
-		if !ok {
-			parsed.CloseFiles()
-			return
+			token := auth.PopAccessToken(stream.Values())
+			if token != "" && auth.GetToken(r.Context()) != nil {
+				resp.WriteInvalidRequest(w, "access token must appear in header or body, not both")
+				return false
+			}
+
+			r, ok = middleware.EnsureTokenForRequest(st, w, r, token)
+			if !ok {
+				return false
+			}
+
+			if !common.RequireScope(w, r, auth.ScopeMedia) {
+				return false
+			}
+
+			return middleware.RequireBudget(st, w, r, ratelimit.ClassMedia)
 		}
 
-		defer parsed.CloseFiles()
+		var url string
+		for {
+			part, err := stream.NextFile()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				common.LogAndWriteError(w, r, "parse multipart", err)
+				return
+			}
 
-		file := parsed.FileByKey("file")
-		if file == nil {
-			resp.WriteInvalidRequest(w, "no file uploaded with field name 'file'")
-			return
+			// Only the first file sent as "file" is stored; the remaining parts are read for their
+			// fields.
+			if part.FormName() != "file" || url != "" {
+				continue
+			}
+
+			if !authorize() {
+				return
+			}
+
+			url, err = common.StoreUpload(r.Context(), state.GetSite(r.Context()), limits, part, -1)
+			if err != nil {
+				common.LogAndWriteError(w, r, "upload media", err)
+				return
+			}
 		}
 
-		fileId := uuid.New().String()
-		fileKey, err := st.MediaPathPattern.Generate(fileId)
-		if err != nil {
-			common.LogAndWriteError(w, r, "generate path from pattern", err)
+		if !authorized && !authorize() {
 			return
 		}
 
-		url, err := st.MediaStore.Upload(r.Context(), &file.File, file.Header, fileKey)
-		if err != nil {
-			common.LogAndWriteError(w, r, "upload media", err)
+		if url == "" {
+			resp.WriteInvalidRequest(w, "no file uploaded with field name 'file'")
 			return
 		}
 
+		site := state.GetSite(r.Context())
+
+		// Not part of the Micropub spec, but lets clients describe an upload for q=source.
+		if alt, ok := stream.Values()["alt"].(string); ok && alt != "" {
+			if _, err := site.MediaIndex.Update(url, func(e *mediaindex.Entry) { e.Alt = alt }); err != nil {
+				slog.ErrorContext(r.Context(), "could not record alt text", "url", url, "error", err)
+			}
+		}
+
+		common.PublishEvent(r, events.MediaUploaded, url, nil)
 		resp.WriteCreated(w, url)
 	}
 }
@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/body"
+	"github.com/indieinfra/scribble/server/handler/common"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/metrics"
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
+)
+
+// DispatchMediaGet answers the media endpoint queries: q=last for the most recent upload and
+// q=source for a paginated list of uploads, newest first.
+func DispatchMediaGet(st *state.ScribbleState) http.HandlerFunc {
+	handlers := map[string]func(*state.Site, http.ResponseWriter, *http.Request, body.QueryParams){
+		"last":   handleLast,
+		"source": handleSource,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := body.ReadQueryParams(r)
+		query := params.GetFirst("q")
+
+		handler, ok := handlers[query]
+		if !ok {
+			resp.WriteInvalidRequest(w, "Unknown GET request")
+			return
+		}
+
+		metrics.SetAction(r.Context(), "q="+query)
+
+		if !common.RequireScope(w, r, auth.ScopeMedia) {
+			return
+		}
+
+		handler(state.GetSite(r.Context()), w, r, params)
+	}
+}
+
+func handleLast(site *state.Site, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+	last, err := site.MediaIndex.List(0, 1)
+	if err != nil {
+		common.LogAndWriteError(w, r, "q=last", err)
+		return
+	}
+	if len(last) == 0 {
+		resp.WriteOK(w, map[string]any{})
+		return
+	}
+
+	resp.WriteOK(w, describe(last[0]))
+}
+
+func handleSource(site *state.Site, w http.ResponseWriter, r *http.Request, p body.QueryParams) {
+	page := p.GetIntOrDefault("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	perPage := site.Content.Pagination.PerPage
+	limit := p.GetIntOrDefault("limit", perPage)
+	if limit < 1 || limit > perPage {
+		limit = perPage
+	}
+
+	entries, err := site.MediaIndex.List((page-1)*limit, limit)
+	if err != nil {
+		common.LogAndWriteError(w, r, "q=source", err)
+		return
+	}
+
+	items := make([]map[string]any, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, describe(e))
+	}
+
+	resp.WriteOK(w, map[string]any{"items": items})
+}
+
+// describe renders an index entry in the shape of the Micropub media endpoint extension, with
+// Scribble's additional fields.
+func describe(e mediaindex.Entry) map[string]any {
+	item := map[string]any{
+		"url":       e.Url,
+		"published": e.UploadedAt.Local().Format(time.RFC3339),
+	}
+
+	if e.MimeType != "" {
+		item["mime_type"] = e.MimeType
+	}
+	if e.Size > 0 {
+		item["size"] = e.Size
+	}
+	if e.ClientId != "" {
+		item["client_id"] = e.ClientId
+	}
+	if e.Alt != "" {
+		item["alt"] = e.Alt
+	}
+	if len(e.Variants) > 0 {
+		item["variants"] = e.Variants
+	}
+	if e.Duration > 0 {
+		item["duration"] = e.Duration
+	}
+	if e.VideoCodec != "" {
+		item["video_codec"] = e.VideoCodec
+	}
+	if e.AudioCodec != "" {
+		item["audio_codec"] = e.AudioCodec
+	}
+	if e.Width > 0 && e.Height > 0 {
+		item["width"] = e.Width
+		item["height"] = e.Height
+	}
+	if e.Poster != "" {
+		item["poster"] = e.Poster
+	}
+
+	return item
+}
@@ -0,0 +1,388 @@
+package upload
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/handler/common"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/middleware"
+	"github.com/indieinfra/scribble/server/ratelimit"
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/resumable"
+	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/util"
+)
+
+// TusVersion is the version of the tus resumable upload protocol spoken on /media/uploads.
+const TusVersion = "1.0.0"
+
+// TusExtensions lists the tus protocol extensions supported.
+const TusExtensions = "creation,termination,expiration"
+
+// HandleResumableCreate starts a resumable upload. The client declares the file's size in
+// Upload-Length and may describe it in Upload-Metadata; the upload's URL is returned in Location, to
+// which the file is then sent in one or more PATCH requests.
+func HandleResumableCreate(st *state.ScribbleState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+
+		r, site, ok := authorizeResumable(st, w, r)
+		if !ok {
+			return
+		}
+
+		if !middleware.RequireBudget(st, w, r, ratelimit.ClassMedia) {
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			resp.WriteInvalidRequest(w, "Upload-Length must be given as a non-negative number of bytes")
+			return
+		}
+
+		if limit := util.LargestFileSize(&st.Cfg.Server.Limits); limit > 0 && length > limit {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(limit, 10))
+			resp.WriteTooLarge(w, fmt.Sprintf("uploads may be at most %d bytes", limit))
+			return
+		}
+
+		metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			resp.WriteInvalidRequest(w, err.Error())
+			return
+		}
+
+		if removed, err := site.Uploads.Sweep(); err != nil {
+			slog.WarnContext(r.Context(), "could not sweep expired uploads", "error", err)
+		} else if removed > 0 {
+			slog.InfoContext(r.Context(), "discarded expired uploads", "count", removed)
+		}
+
+		var clientId string
+		if token := auth.GetToken(r.Context()); token != nil {
+			clientId = token.ClientId
+		}
+
+		upload, err := site.Uploads.Create(length, metadata, clientId)
+		if err != nil {
+			common.LogAndWriteError(w, r, "create upload", err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "resumable upload created", "id", upload.ID, "length", length)
+		writeExpires(w, site, upload)
+		resp.WriteCreated(w, "/media/uploads/"+upload.ID)
+	}
+}
+
+// HandleResumableHead reports how much of an upload has been received, so a client can resume it.
+func HandleResumableHead(st *state.ScribbleState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+		w.Header().Set("Cache-Control", "no-store")
+
+		r, site, ok := authorizeResumable(st, w, r)
+		if !ok {
+			return
+		}
+
+		upload, ok := lookupUpload(w, r, site)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Url != "" {
+			w.Header().Set("Location", upload.Url)
+		}
+		writeExpires(w, site, upload)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HandleResumablePatch appends a chunk to an upload. Upload-Offset must match what has been received
+// so far. Once the last byte arrives the file is stored as though it had been sent to the media
+// endpoint, and its URL is returned in Location with 201 Created.
+func HandleResumablePatch(st *state.ScribbleState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+
+		r, site, ok := authorizeResumable(st, w, r)
+		if !ok {
+			return
+		}
+
+		mediaType, ok := util.ExtractMediaType(w, r)
+		if !ok {
+			return
+		}
+		if mediaType != "application/offset+octet-stream" {
+			resp.WriteUnsupportedMediaType(w, "chunks must be sent as application/offset+octet-stream")
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			resp.WriteInvalidRequest(w, "Upload-Offset must be given as a non-negative number of bytes")
+			return
+		}
+
+		upload, ok := lookupUpload(w, r, site)
+		if !ok {
+			return
+		}
+
+		upload, err = site.Uploads.Append(upload.ID, offset, r.Body)
+		switch {
+		case errors.Is(err, resumable.ErrOffsetMismatch):
+			resp.WriteConflict(w, fmt.Sprintf("upload is at offset %d", upload.Offset))
+			return
+		case errors.Is(err, resumable.ErrBusy):
+			resp.WriteConflict(w, "another chunk is being written to this upload")
+			return
+		case errors.Is(err, resumable.ErrNotFound):
+			resp.WriteNotFound(w, "upload not found")
+			return
+		case errors.Is(err, resumable.ErrTooLong):
+			resp.WriteInvalidRequest(w, "chunk extends past Upload-Length")
+			return
+		case err != nil:
+			// Whatever arrived before the connection failed is kept; the client resumes from there.
+			common.LogAndWriteError(w, r, "append upload", err)
+			return
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		writeExpires(w, site, upload)
+
+		if !verifyUpload(st, w, r, site, upload) {
+			return
+		}
+
+		if !upload.Complete() {
+			resp.WriteNoContent(w)
+			return
+		}
+
+		finishUpload(st, w, r, site, upload.ID)
+	}
+}
+
+// HandleResumableDelete abandons an upload, discarding what has been received.
+func HandleResumableDelete(st *state.ScribbleState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+
+		r, site, ok := authorizeResumable(st, w, r)
+		if !ok {
+			return
+		}
+
+		upload, ok := lookupUpload(w, r, site)
+		if !ok {
+			return
+		}
+
+		if err := site.Uploads.Remove(upload.ID); err != nil {
+			if errors.Is(err, resumable.ErrBusy) {
+				resp.WriteConflict(w, "another chunk is being written to this upload")
+				return
+			}
+			common.LogAndWriteError(w, r, "delete upload", err)
+			return
+		}
+
+		resp.WriteNoContent(w)
+	}
+}
+
+// HandleResumableOptions describes the server's tus support.
+func HandleResumableOptions(st *state.ScribbleState, methods ...string) http.Handler {
+	preflight := middleware.CorsPreflightHandler(&st.Cfg.Server.Cors, methods...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+		w.Header().Set("Tus-Version", TusVersion)
+		w.Header().Set("Tus-Extension", TusExtensions)
+		if limit := util.LargestFileSize(&st.Cfg.Server.Limits); limit > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(limit, 10))
+		}
+
+		preflight.ServeHTTP(w, r)
+	})
+}
+
+// authorizeResumable requires a bearer token with the media scope, for a site that has resumable
+// uploads enabled. Tokens must be sent in the Authorization header, since chunks have no form body.
+func authorizeResumable(st *state.ScribbleState, w http.ResponseWriter, r *http.Request) (*http.Request, *state.Site, bool) {
+	if v := r.Header.Get("Tus-Resumable"); v != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		resp.WritePreconditionFailed(w, fmt.Sprintf("unsupported Tus-Resumable version %q", v))
+		return r, nil, false
+	}
+
+	r, ok := middleware.EnsureTokenForRequest(st, w, r, "")
+	if !ok {
+		return r, nil, false
+	}
+
+	if !common.RequireScope(w, r, auth.ScopeMedia) {
+		return r, nil, false
+	}
+
+	site := state.GetSite(r.Context())
+	if site.Uploads == nil {
+		resp.WriteNotFound(w, "resumable uploads are not enabled for this site")
+		return r, nil, false
+	}
+
+	return r, site, true
+}
+
+// lookupUpload finds the upload named in the path, which must belong to the requesting client.
+func lookupUpload(w http.ResponseWriter, r *http.Request, site *state.Site) (*resumable.Upload, bool) {
+	upload, err := site.Uploads.Get(r.PathValue("id"))
+	if errors.Is(err, resumable.ErrNotFound) {
+		resp.WriteNotFound(w, "upload not found")
+		return nil, false
+	}
+	if err != nil {
+		common.LogAndWriteError(w, r, "read upload", err)
+		return nil, false
+	}
+
+	if token := auth.GetToken(r.Context()); token != nil && upload.ClientId != token.ClientId {
+		resp.WriteNotFound(w, "upload not found")
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// verifyUpload checks the upload's type against the site's allowlist as soon as enough of it has
+// arrived to tell, so a disallowed file is refused long before it is complete. A refused upload is
+// discarded.
+func verifyUpload(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, site *state.Site, upload *resumable.Upload) bool {
+	if upload.Checked || (upload.Offset < util.SniffLen && !upload.Complete()) {
+		return true
+	}
+
+	data, err := site.Uploads.Data(upload.ID)
+	if err != nil {
+		common.LogAndWriteError(w, r, "read upload", err)
+		return false
+	}
+	contentType, err := util.SniffContentType(data)
+	data.Close()
+	if err != nil {
+		common.LogAndWriteError(w, r, "read upload", err)
+		return false
+	}
+
+	if err := common.CheckUploadType(site, &st.Cfg.Server.Limits, contentType, upload.Length); err != nil {
+		if err := site.Uploads.Remove(upload.ID); err != nil {
+			slog.ErrorContext(r.Context(), "could not discard rejected upload", "id", upload.ID, "error", err)
+		}
+		common.LogAndWriteError(w, r, "upload media", err)
+		return false
+	}
+
+	if _, err := site.Uploads.Update(upload.ID, func(u *resumable.Upload) { u.Checked = true }); err != nil {
+		common.LogAndWriteError(w, r, "update upload", err)
+		return false
+	}
+
+	return true
+}
+
+// finishUpload stores a complete upload in the media store and responds with its URL.
+func finishUpload(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, site *state.Site, id string) {
+	if !site.Uploads.Acquire(id) {
+		resp.WriteConflict(w, "this upload is already being finished")
+		return
+	}
+	defer site.Uploads.Release(id)
+
+	// A retry after a lost response finds the upload already stored.
+	upload, err := site.Uploads.Get(id)
+	if err != nil {
+		common.LogAndWriteError(w, r, "read upload", err)
+		return
+	}
+	if upload.Url != "" {
+		resp.WriteCreated(w, upload.Url)
+		return
+	}
+
+	data, err := site.Uploads.Data(id)
+	if err != nil {
+		common.LogAndWriteError(w, r, "read upload", err)
+		return
+	}
+	defer data.Close()
+
+	url, err := common.StoreUpload(r.Context(), site, &st.Cfg.Server.Limits, data, upload.Length)
+	if err != nil {
+		common.LogAndWriteError(w, r, "upload media", err)
+		return
+	}
+
+	if err := site.Uploads.Finish(id, url); err != nil {
+		slog.ErrorContext(r.Context(), "could not discard finished upload", "id", id, "error", err)
+	}
+
+	// Clients may describe the file with "alt" in Upload-Metadata, as with the media endpoint's field.
+	if alt := upload.Metadata["alt"]; alt != "" {
+		if _, err := site.MediaIndex.Update(url, func(e *mediaindex.Entry) { e.Alt = alt }); err != nil {
+			slog.ErrorContext(r.Context(), "could not record alt text", "url", url, "error", err)
+		}
+	}
+
+	slog.InfoContext(r.Context(), "resumable upload finished", "id", id, "url", url)
+	common.PublishEvent(r, events.MediaUploaded, url, nil)
+	resp.WriteCreated(w, url)
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated pairs of a key and an
+// optional base64-encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+
+	metadata := make(map[string]string)
+	for pair := range strings.SplitSeq(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata has an empty key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value for %q is not valid base64", key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// writeExpires tells the client when an unfinished upload will be discarded.
+func writeExpires(w http.ResponseWriter, site *state.Site, upload *resumable.Upload) {
+	if upload.Url != "" {
+		return
+	}
+
+	expires := upload.CreatedAt.Add(site.Uploads.Expiry())
+	w.Header().Set("Upload-Expires", expires.Format(http.TimeFormat))
+}
@@ -0,0 +1,290 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+const (
+	defaultQuality   = 85
+	defaultMaxPixels = 50_000_000
+)
+
+// ErrUnsupported is returned for images the pipeline cannot decode: WebP and AVIF sources (the
+// standard library has no decoder for them) and animated GIFs (resizing would drop every frame but
+// the first). Such uploads are stored as they are, without variants.
+var ErrUnsupported = errors.New("image format not supported for processing")
+
+// Rendition is one generated variant of an uploaded image.
+type Rendition struct {
+	Name        string `json:"name"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"type"`
+	Ext         string `json:"-"`
+	Data        []byte `json:"-"`
+}
+
+// Processor generates the configured size variants of uploaded images.
+type Processor struct {
+	cfg       *config.Images
+	quality   int
+	maxPixels int
+}
+
+func NewProcessor(cfg *config.Images) *Processor {
+	p := &Processor{cfg: cfg, quality: cfg.Quality, maxPixels: cfg.MaxPixels}
+	if p.quality == 0 {
+		p.quality = defaultQuality
+	}
+	if p.maxPixels == 0 {
+		p.maxPixels = defaultMaxPixels
+	}
+
+	return p
+}
+
+// Process decodes data and returns a rendition for each configured variant narrower than the
+// original, in each configured format. Variants are never upscaled.
+func (p *Processor) Process(ctx context.Context, data []byte) ([]Rendition, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	// Check dimensions before decoding so a small file cannot expand into an enormous bitmap.
+	if cfg.Width*cfg.Height > p.maxPixels {
+		return nil, fmt.Errorf("image of %dx%d exceeds the %d pixel limit", cfg.Width, cfg.Height, p.maxPixels)
+	}
+
+	if format == "gif" {
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if len(anim.Image) > 1 {
+			return nil, ErrUnsupported
+		}
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Variants carry no EXIF, so a photo stored sideways with an orientation tag (as phones do) is
+	// turned the right way up before resizing.
+	if meta, err := ReadMetadata(data); err == nil && meta.Orientation > 1 {
+		src = Orient(src, meta.Orientation)
+		cfg.Width = src.Bounds().Dx()
+	}
+
+	formats := p.cfg.Formats
+	if len(formats) == 0 {
+		formats = []string{"original"}
+	}
+
+	var out []Rendition
+	for _, variant := range p.cfg.Variants {
+		if variant.Width >= cfg.Width {
+			continue
+		}
+
+		resized := Resize(src, variant.Width)
+		for _, f := range formats {
+			if f == "original" {
+				f = format
+			}
+
+			encoded, contentType, ext, err := p.encode(ctx, resized, f)
+			if err != nil {
+				slog.WarnContext(ctx, "could not encode image variant", "variant", variant.Name, "format", f, "error", err)
+				continue
+			}
+
+			out = append(out, Rendition{
+				Name:        variant.Name,
+				Width:       resized.Bounds().Dx(),
+				Height:      resized.Bounds().Dy(),
+				ContentType: contentType,
+				Ext:         ext,
+				Data:        encoded,
+			})
+		}
+	}
+
+	return out, nil
+}
+
+// encode writes img in the named format. GIF variants are written as PNG, which keeps transparency
+// without the 256 colour palette. WebP and AVIF are produced by the configured external encoders.
+func (p *Processor) encode(ctx context.Context, img image.Image, format string) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+
+	switch format {
+	case "jpeg":
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.quality}); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "image/jpeg", "jpg", nil
+	case "png", "gif":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "image/png", "png", nil
+	case "webp":
+		data, err := p.external(ctx, img, p.cfg.Encoders.Webp, "webp")
+		return data, "image/webp", "webp", err
+	case "avif":
+		data, err := p.external(ctx, img, p.cfg.Encoders.Avif, "avif")
+		return data, "image/avif", "avif", err
+	}
+
+	return nil, "", "", fmt.Errorf("unknown format %q", format)
+}
+
+// external encodes img with a command-line encoder (cwebp or avifenc), invoked as
+// "<command> -q <quality> <input.png> -o <output>" for cwebp and "<command> -q <quality> <input.png>
+// <output>" otherwise.
+func (p *Processor) external(ctx context.Context, img image.Image, command string, ext string) ([]byte, error) {
+	if command == "" {
+		return nil, fmt.Errorf("no %s encoder configured", ext)
+	}
+
+	dir, err := os.MkdirTemp("", "scribble-image-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out."+ext)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+
+	quality := fmt.Sprint(p.quality)
+	args := []string{"-q", quality, in, out}
+	if ext == "webp" {
+		args = []string{"-quiet", "-q", quality, in, "-o", out}
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", command, err, bytes.TrimSpace(output))
+	}
+
+	return os.ReadFile(out)
+}
+
+// Orient returns src turned as its EXIF orientation (2 to 8) says it should be displayed. Other
+// orientations leave it as it is.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, sb.Min, draw.Src)
+
+	// Orientations 5 to 8 swap the axes.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			// Find the source pixel shown at (x, y).
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			si := sy*rgba.Stride + sx*4
+			di := y*dst.Stride + x*4
+			copy(dst.Pix[di:di+4], rgba.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
+
+// Resize scales src to the given width, preserving its aspect ratio, by averaging the source pixels
+// covered by each destination pixel. It is intended for downscaling.
+func Resize(src image.Image, width int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	height := max(1, (sh*width+sw/2)/sw)
+
+	// Work on an RGBA copy so pixel access is a cheap slice lookup.
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, sb.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := range height {
+		y0 := dy * sh / height
+		y1 := max(y0+1, (dy+1)*sh/height)
+
+		for dx := range width {
+			x0 := dx * sw / width
+			x1 := max(x0+1, (dx+1)*sw/width)
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := rgba.Pix[y*rgba.Stride:]
+				for x := x0; x < x1; x++ {
+					px := row[x*4 : x*4+4]
+					r += uint64(px[0])
+					g += uint64(px[1])
+					b += uint64(px[2])
+					a += uint64(px[3])
+					n++
+				}
+			}
+
+			i := dy*dst.Stride + dx*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
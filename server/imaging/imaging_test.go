@@ -0,0 +1,132 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// sidewaysPhoto returns a 40x20 JPEG, red on the left and blue on the right, tagged with an EXIF
+// orientation, as a phone stores a portrait photo.
+func sidewaysPhoto(t *testing.T, orientation int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := range 20 {
+		for x := range 40 {
+			if x < 20 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	return append(append(data[:2:2], orientationSegment(orientation)...), data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r>>8 > 200 && g>>8 < 60 && b>>8 < 60
+}
+
+func isBlue(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return b>>8 > 200 && r>>8 < 60 && g>>8 < 60
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	data := sidewaysPhoto(t, 6)
+
+	if meta, err := ReadMetadata(data); err != nil || meta.Orientation != 6 {
+		t.Fatalf("fixture orientation = %v, err = %v", meta, err)
+	}
+
+	p := NewProcessor(&config.Images{Variants: []config.ImageVariant{{Name: "small", Width: 10}}})
+	renditions, err := p.Process(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != 1 {
+		t.Fatalf("got %d renditions, want 1", len(renditions))
+	}
+
+	r := renditions[0]
+	if r.Width != 10 || r.Height != 20 {
+		t.Errorf("variant is %dx%d, want 10x20 (portrait)", r.Width, r.Height)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(r.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 20 {
+		t.Errorf("encoded variant is %dx%d, want 10x20", b.Dx(), b.Dy())
+	}
+
+	// Turning the photo a quarter clockwise brings its left (red) side to the top.
+	if top, bottom := img.At(5, 2), img.At(5, 17); !isRed(top) || !isBlue(bottom) {
+		t.Errorf("top = %v, bottom = %v; want red above blue", top, bottom)
+	}
+}
+
+func TestProcessSkipsVariantsWiderThanOrientedImage(t *testing.T) {
+	// Displayed upright the photo is only 20 pixels wide, so a 30 pixel variant would be an upscale.
+	p := NewProcessor(&config.Images{Variants: []config.ImageVariant{{Name: "medium", Width: 30}}})
+	renditions, err := p.Process(context.Background(), sidewaysPhoto(t, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != 0 {
+		t.Errorf("got %d renditions, want none", len(renditions))
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 2x1 image: red then blue.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		width       int
+		redAt       image.Point
+	}{
+		{1, 2, image.Pt(0, 0)},
+		{2, 2, image.Pt(1, 0)},
+		{3, 2, image.Pt(1, 0)},
+		{4, 2, image.Pt(0, 0)},
+		{5, 1, image.Pt(0, 0)},
+		{6, 1, image.Pt(0, 0)},
+		{7, 1, image.Pt(0, 1)},
+		{8, 1, image.Pt(0, 1)},
+	}
+
+	for _, tt := range tests {
+		got := Orient(src, tt.orientation)
+		if got.Bounds().Dx() != tt.width {
+			t.Errorf("orientation %d: width = %d, want %d", tt.orientation, got.Bounds().Dx(), tt.width)
+			continue
+		}
+		if !isRed(got.At(tt.redAt.X, tt.redAt.Y)) {
+			t.Errorf("orientation %d: red pixel not at %v", tt.orientation, tt.redAt)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrMalformed is returned when an image claims to be JPEG, PNG or WebP but its structure cannot be
+// parsed, so its metadata cannot be reliably removed.
+var ErrMalformed = errors.New("malformed image")
+
+var (
+	jpegSignature = []byte{0xFF, 0xD8}
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader    = []byte("Exif\x00\x00")
+)
+
+// Metadata is what Scribble reads from a photo's EXIF data before removing it.
+type Metadata struct {
+	// Taken is the capture time, or the zero time if unknown.
+	Taken time.Time
+	// HasLocation reports whether Latitude, Longitude and, if HasAltitude, Altitude were present.
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+	HasAltitude bool
+	Altitude    float64
+	// Orientation is the EXIF orientation (1 to 8), or 0 if absent.
+	Orientation int
+}
+
+// GeoUri formats the location as an RFC 5870 geo URI, or returns "" if there is no location.
+func (m *Metadata) GeoUri() string {
+	if !m.HasLocation {
+		return ""
+	}
+
+	uri := fmt.Sprintf("geo:%.6f,%.6f", m.Latitude, m.Longitude)
+	if m.HasAltitude {
+		uri += fmt.Sprintf(",%.1f", m.Altitude)
+	}
+
+	return uri
+}
+
+// StripMetadata removes EXIF, XMP, IPTC and textual metadata, which can carry the location, time and
+// device a photo was taken with, from JPEG, PNG and WebP images. Colour profiles are kept, and a
+// JPEG's EXIF orientation is preserved in a minimal EXIF block of its own so the photo still displays
+// the right way up. Other data is returned unchanged.
+func StripMetadata(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSignature):
+		return stripJpeg(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPng(data)
+	case isWebp(data):
+		return stripWebp(data)
+	}
+
+	return data, nil
+}
+
+// ReadMetadata extracts the capture time, location and orientation from a JPEG, PNG or WebP image's
+// EXIF data. It returns an empty Metadata if there is none.
+func ReadMetadata(data []byte) (*Metadata, error) {
+	var tiff []byte
+	var err error
+
+	switch {
+	case bytes.HasPrefix(data, jpegSignature):
+		_, err = walkJpeg(data, func(marker byte, payload, _ []byte) bool {
+			if marker == 0xE1 && bytes.HasPrefix(payload, exifHeader) {
+				tiff = payload[len(exifHeader):]
+				return false
+			}
+			return true
+		})
+	case bytes.HasPrefix(data, pngSignature):
+		err = walkPng(data, func(typ string, payload, _ []byte) bool {
+			if typ == "eXIf" {
+				tiff = payload
+				return false
+			}
+			return true
+		})
+	case isWebp(data):
+		err = walkWebp(data, func(fourcc string, payload, _ []byte) bool {
+			if fourcc == "EXIF" {
+				tiff = bytes.TrimPrefix(payload, exifHeader)
+				return false
+			}
+			return true
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if tiff == nil {
+		return &Metadata{}, nil
+	}
+
+	return parseExif(tiff)
+}
+
+// walkJpeg calls fn with the payload and raw bytes of every marker segment before the image data,
+// and returns the offset at which the image data starts. fn returns false to stop.
+func walkJpeg(data []byte, fn func(marker byte, payload, segment []byte) bool) (int, error) {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 0, fmt.Errorf("%w: expected jpeg marker at offset %d", ErrMalformed, pos)
+		}
+
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte.
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan or end of image: no metadata follows.
+			return pos, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return 0, fmt.Errorf("%w: truncated jpeg segment", ErrMalformed)
+		}
+
+		if !fn(marker, data[pos+4:end], data[pos:end]) {
+			return end, nil
+		}
+		pos = end
+	}
+
+	return 0, fmt.Errorf("%w: jpeg ended before image data", ErrMalformed)
+}
+
+// keepJpegSegment reports whether a segment is needed to display the image: JFIF (APP0), ICC
+// profiles (APP2) and Adobe colour transforms (APP14) are kept along with every non-APPn segment
+// except comments.
+func keepJpegSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0, marker == 0xEE:
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+
+	return true
+}
+
+func stripJpeg(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(jpegSignature)
+
+	orientation := 0
+	scan, err := walkJpeg(data, func(marker byte, payload, segment []byte) bool {
+		if marker == 0xE1 && bytes.HasPrefix(payload, exifHeader) {
+			if meta, err := parseExif(payload[len(exifHeader):]); err == nil {
+				orientation = meta.Orientation
+			}
+		}
+
+		if keepJpegSegment(marker, payload) {
+			out.Write(segment)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := out.Bytes()
+	if orientation > 1 {
+		// Insert the orientation-only EXIF block straight after SOI, or after APP0 where one leads.
+		at := 2
+		if len(stripped) > 6 && stripped[3] == 0xE0 {
+			at += 2 + int(binary.BigEndian.Uint16(stripped[4:]))
+		}
+		stripped = append(stripped[:at:at], append(orientationSegment(orientation), stripped[at:]...)...)
+	}
+
+	return append(stripped, data[scan:]...), nil
+}
+
+// orientationSegment builds an APP1 segment holding an EXIF block with only the orientation tag.
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big-endian header, IFD0 at offset 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // orientation, SHORT, count 1
+		0x00, byte(orientation), 0x00, 0x00, // value
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// walkPng calls fn with the payload and raw bytes of every chunk. fn returns false to stop.
+func walkPng(data []byte, fn func(typ string, payload, chunk []byte) bool) error {
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return fmt.Errorf("%w: truncated png chunk", ErrMalformed)
+		}
+
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return fmt.Errorf("%w: truncated png chunk", ErrMalformed)
+		}
+
+		if !fn(string(data[pos+4:pos+8]), data[pos+8:pos+8+length], data[pos:end]) {
+			return nil
+		}
+		pos = end
+	}
+
+	return nil
+}
+
+func stripPng(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	err := walkPng(data, func(typ string, _, chunk []byte) bool {
+		switch typ {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out.Write(chunk)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func isWebp(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// walkWebp calls fn with the payload and raw bytes (including padding) of every RIFF chunk. fn
+// returns false to stop.
+func walkWebp(data []byte, fn func(fourcc string, payload, chunk []byte) bool) error {
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return fmt.Errorf("%w: truncated webp chunk", ErrMalformed)
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return fmt.Errorf("%w: truncated webp chunk", ErrMalformed)
+		}
+
+		// Chunks are padded to an even length.
+		padded := min(end+size%2, len(data))
+		if !fn(string(data[pos:pos+4]), data[pos+8:end], data[pos:padded]) {
+			return nil
+		}
+		pos = padded
+	}
+
+	return nil
+}
+
+func stripWebp(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	err := walkWebp(data, func(fourcc string, payload, chunk []byte) bool {
+		switch fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk = bytes.Clone(chunk)
+			if len(payload) > 0 {
+				// Clear the EXIF (0x08) and XMP (0x04) presence flags.
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out.Write(chunk)
+		default:
+			out.Write(chunk)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+
+	return stripped, nil
+}
+
+// EXIF tags read by parseExif.
+const (
+	tagOrientation        = 0x0112
+	tagExifIfd            = 0x8769
+	tagGpsIfd             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGpsLatitudeRef     = 0x0001
+	tagGpsLatitude        = 0x0002
+	tagGpsLongitudeRef    = 0x0003
+	tagGpsLongitude       = 0x0004
+	tagGpsAltitudeRef     = 0x0005
+	tagGpsAltitude        = 0x0006
+)
+
+// typeSizes are the byte sizes of the TIFF field types.
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+func parseExif(tiff []byte) (*Metadata, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("%w: exif block too short", ErrMalformed)
+	}
+
+	t := &tiffReader{data: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: unknown exif byte order", ErrMalformed)
+	}
+
+	ifd0, err := t.readIfd(int(t.order.Uint32(tiff[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{}
+	if e, ok := ifd0[tagOrientation]; ok {
+		meta.Orientation = int(t.uint(e, 0))
+	}
+
+	if e, ok := ifd0[tagExifIfd]; ok {
+		if exif, err := t.readIfd(int(t.uint(e, 0))); err == nil {
+			meta.Taken = captureTime(t.string(exif[tagDateTimeOriginal]), t.string(exif[tagOffsetTimeOriginal]))
+		}
+	}
+
+	if e, ok := ifd0[tagGpsIfd]; ok {
+		if gps, err := t.readIfd(int(t.uint(e, 0))); err == nil {
+			lat, latOk := t.degrees(gps[tagGpsLatitude])
+			lon, lonOk := t.degrees(gps[tagGpsLongitude])
+			if latOk && lonOk {
+				if strings.EqualFold(t.string(gps[tagGpsLatitudeRef]), "S") {
+					lat = -lat
+				}
+				if strings.EqualFold(t.string(gps[tagGpsLongitudeRef]), "W") {
+					lon = -lon
+				}
+				meta.HasLocation, meta.Latitude, meta.Longitude = true, lat, lon
+
+				if alt, ok := gps[tagGpsAltitude]; ok && alt.typ == 5 && alt.count >= 1 {
+					meta.HasAltitude, meta.Altitude = true, t.rational(alt, 0)
+					// An altitude reference of 1 means below sea level.
+					if ref, ok := gps[tagGpsAltitudeRef]; ok && len(ref.value) > 0 && ref.value[0] == 1 {
+						meta.Altitude = -meta.Altitude
+					}
+				}
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+func (t *tiffReader) readIfd(offset int) (map[uint16]ifdEntry, error) {
+	if offset < 8 || offset+2 > len(t.data) {
+		return nil, fmt.Errorf("%w: exif directory out of range", ErrMalformed)
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	if offset+2+count*12 > len(t.data) {
+		return nil, fmt.Errorf("%w: exif directory out of range", ErrMalformed)
+	}
+
+	entries := make(map[uint16]ifdEntry, count)
+	for i := range count {
+		raw := t.data[offset+2+i*12:]
+		typ := t.order.Uint16(raw[2:])
+		n := int(t.order.Uint32(raw[4:]))
+
+		size, ok := typeSizes[typ]
+		if !ok || n < 0 || n > len(t.data) {
+			continue
+		}
+
+		value := raw[8:12]
+		if size*n > 4 {
+			at := int(t.order.Uint32(raw[8:]))
+			if at < 0 || at+size*n > len(t.data) {
+				continue
+			}
+			value = t.data[at : at+size*n]
+		}
+
+		entries[t.order.Uint16(raw)] = ifdEntry{typ: typ, count: n, value: value}
+	}
+
+	return entries, nil
+}
+
+func (t *tiffReader) uint(e ifdEntry, i int) uint32 {
+	switch e.typ {
+	case 3:
+		if len(e.value) >= 2*(i+1) {
+			return uint32(t.order.Uint16(e.value[2*i:]))
+		}
+	case 4:
+		if len(e.value) >= 4*(i+1) {
+			return t.order.Uint32(e.value[4*i:])
+		}
+	}
+
+	return 0
+}
+
+func (t *tiffReader) rational(e ifdEntry, i int) float64 {
+	if len(e.value) < 8*(i+1) {
+		return 0
+	}
+
+	num, den := t.order.Uint32(e.value[8*i:]), t.order.Uint32(e.value[8*i+4:])
+	if den == 0 {
+		return 0
+	}
+
+	return float64(num) / float64(den)
+}
+
+func (t *tiffReader) string(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// degrees converts a degrees, minutes, seconds triple to decimal degrees.
+func (t *tiffReader) degrees(e ifdEntry) (float64, bool) {
+	if e.typ != 5 || e.count < 3 {
+		return 0, false
+	}
+
+	deg := t.rational(e, 0) + t.rational(e, 1)/60 + t.rational(e, 2)/3600
+	if math.IsNaN(deg) || deg > 180 {
+		return 0, false
+	}
+
+	return deg, true
+}
+
+// captureTime parses an EXIF date ("2006:01:02 15:04:05") with its optional UTC offset ("+09:00").
+// Without an offset the time is assumed to be in the server's local time zone.
+func captureTime(value string, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t
+		}
+	}
+
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
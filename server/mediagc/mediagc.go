@@ -0,0 +1,344 @@
+package mediagc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+	storageutil "github.com/indieinfra/scribble/storage/util"
+)
+
+const defaultGracePeriod = 24 * time.Hour
+
+// ErrUnstableScan indicates that posts changed while they were being scanned for references, so a
+// collection was abandoned rather than risk deleting media that is still in use.
+var ErrUnstableScan = errors.New("content changed during media collection scan")
+
+// Report describes the outcome of a collection.
+type Report struct {
+	DryRun bool `json:"dry_run"`
+	// Scanned counts the media files examined.
+	Scanned int `json:"scanned"`
+	// Orphans lists the files no post refers to that are past the grace period. Outside dry-run mode
+	// they have been deleted, except those listed in Errors.
+	Orphans []string          `json:"orphans"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Collector deletes uploaded media that no live post refers to.
+type Collector struct {
+	me         string
+	content    content.Store
+	contentCfg *config.Content
+	media      media.Store
+	mediaBase  string
+	index      *mediaindex.Index
+	bus        *events.Bus
+	grace      time.Duration
+	dryRun     bool
+	interval   time.Duration
+	running    sync.Mutex
+
+	// ctx is cancelled by Close, stopping scheduled collections.
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneCh chan struct{}
+}
+
+// New creates a collector for a site. Media is inventoried from the index, and posts are read from
+// the content store. Deletions are announced on the bus as media.deleted events.
+func New(me string, cfg *config.MediaGC, contentCfg *config.Content, contentStore content.Store, mediaCfg *config.Media, mediaStore media.Store, index *mediaindex.Index, bus *events.Bus) *Collector {
+	grace := cfg.GracePeriod
+	if grace == 0 {
+		grace = defaultGracePeriod
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Collector{
+		me:         me,
+		content:    contentStore,
+		contentCfg: contentCfg,
+		media:      mediaStore,
+		mediaBase:  storageutil.NormalizeBaseURL(mediaCfg.PublicBaseUrl),
+		index:      index,
+		bus:        bus,
+		grace:      grace,
+		dryRun:     cfg.DryRun,
+		interval:   cfg.Interval,
+		ctx:        ctx,
+		cancel:     cancel,
+		doneCh:     make(chan struct{}),
+	}
+}
+
+// Start runs a collection every configured interval until Close is called. It does nothing when no
+// interval is configured.
+func (c *Collector) Start() {
+	if c.interval <= 0 {
+		close(c.doneCh)
+		return
+	}
+
+	go func() {
+		defer close(c.doneCh)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := c.Run(c.ctx, false)
+				if err != nil {
+					slog.Error("media collection failed", "me", c.me, "error", err)
+					continue
+				}
+				slog.Info("media collection finished", "me", c.me, "dry_run", report.DryRun, "scanned", report.Scanned, "orphans", len(report.Orphans), "errors", len(report.Errors))
+			}
+		}
+	}()
+}
+
+// Close stops scheduled collections, cancelling one in progress.
+func (c *Collector) Close(ctx context.Context) error {
+	c.cancel()
+
+	select {
+	case <-c.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run performs one collection. In dry-run mode, or when the collector is configured for dry runs,
+// orphans are reported but kept. Only one collection runs at a time.
+func (c *Collector) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	c.running.Lock()
+	defer c.running.Unlock()
+
+	report := &Report{DryRun: dryRun || c.dryRun, Orphans: []string{}}
+
+	entries, err := c.index.List(0, c.index.Len())
+	if err != nil {
+		return nil, err
+	}
+
+	referenced, err := c.references(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-c.grace)
+	for _, entry := range entries {
+		report.Scanned++
+
+		if entry.UploadedAt.After(cutoff) || isReferenced(entry, referenced) {
+			continue
+		}
+
+		report.Orphans = append(report.Orphans, entry.Url)
+		if report.DryRun {
+			slog.InfoContext(ctx, "media collection would delete orphan", "me", c.me, "url", entry.Url)
+			continue
+		}
+
+		if err := c.index.Delete(ctx, c.media, entry.Url); err != nil {
+			if report.Errors == nil {
+				report.Errors = make(map[string]string)
+			}
+			report.Errors[entry.Url] = err.Error()
+			slog.ErrorContext(ctx, "media collection failed to delete orphan", "me", c.me, "url", entry.Url, "error", err)
+			continue
+		}
+
+		slog.InfoContext(ctx, "media collection deleted orphan", "me", c.me, "url", entry.Url)
+		c.bus.Publish(ctx, events.Event{Type: events.MediaDeleted, Url: entry.Url})
+	}
+
+	return report, nil
+}
+
+// Referenced reports whether a post that is not deleted refers to the file entry describes, to one of
+// its variants or to its poster. Posts are read a page at a time until the first one that does, and
+// any the store cannot read fail the check rather than count as not referring to it. A single pass
+// is made, so this stays cheap enough to run on every delete; collections keep their own stability
+// check.
+func Referenced(ctx context.Context, contentCfg *config.Content, contentStore content.Store, mediaCfg *config.Media, entry mediaindex.Entry) (bool, error) {
+	c := &Collector{content: contentStore, contentCfg: contentCfg, mediaBase: storageutil.NormalizeBaseURL(mediaCfg.PublicBaseUrl)}
+
+	for page := 1; ; page++ {
+		docs, err := contentStore.List(ctx, page, contentCfg.Pagination.PerPage)
+		if err != nil {
+			return false, fmt.Errorf("list content: %w", err)
+		}
+		if len(docs) == 0 {
+			return false, nil
+		}
+
+		for _, doc := range docs {
+			if isDeleted(doc) {
+				continue
+			}
+
+			referenced := make(map[string]bool)
+			c.collectUrls(doc, referenced)
+			if isReferenced(entry, referenced) {
+				return true, nil
+			}
+		}
+
+		if !contentCfg.Pagination.Enabled {
+			return false, nil
+		}
+	}
+}
+
+// references collects the media URLs that posts which are not deleted refer to. Besides the photo,
+// video and audio properties, a media URL appearing anywhere else in a post (such as an image embedded
+// in its content) counts, so nothing a post still displays is removed.
+//
+// Posts created, renamed or removed during a scan can shift the pages so that one is never read, so
+// the content is scanned twice and the collection abandoned unless both passes saw every post exactly
+// once. Any document the store fails to read also abandons it: an incomplete scan would delete media
+// that is still in use.
+func (c *Collector) references(ctx context.Context) (map[string]bool, error) {
+	referenced, seen, err := c.scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	again, seenAgain, err := c.scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !maps.Equal(seen, seenAgain) {
+		return nil, ErrUnstableScan
+	}
+	for _, n := range seen {
+		if n != 1 {
+			return nil, ErrUnstableScan
+		}
+	}
+
+	maps.Copy(referenced, again)
+	return referenced, nil
+}
+
+// scan reads every post once, returning the media URLs referred to and how often each post's slug
+// was seen.
+func (c *Collector) scan(ctx context.Context) (map[string]bool, map[string]int, error) {
+	referenced := make(map[string]bool)
+	seen := make(map[string]int)
+	perPage := c.contentCfg.Pagination.PerPage
+
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		docs, err := c.content.List(ctx, page, perPage)
+		if err != nil {
+			return nil, nil, fmt.Errorf("list content: %w", err)
+		}
+
+		// A short page may only mean that the store skipped something, so only an empty one ends the
+		// scan.
+		if len(docs) == 0 {
+			return referenced, seen, nil
+		}
+
+		for _, doc := range docs {
+			slug, err := content.ExtractSlug(doc)
+			if err != nil {
+				return nil, nil, fmt.Errorf("list content: %w", err)
+			}
+			seen[slug]++
+
+			if isDeleted(doc) {
+				continue
+			}
+
+			for _, values := range doc.Properties {
+				c.collectUrls(values, referenced)
+			}
+		}
+
+		// Without pagination the store returns every document at once.
+		if !c.contentCfg.Pagination.Enabled {
+			return referenced, seen, nil
+		}
+	}
+}
+
+// collectUrls records every media URL found in the strings nested within v.
+func (c *Collector) collectUrls(v any, into map[string]bool) {
+	switch x := v.(type) {
+	case string:
+		for rest := x; ; {
+			i := strings.Index(rest, c.mediaBase)
+			if i < 0 {
+				return
+			}
+			rest = rest[i:]
+			end := strings.IndexAny(rest, "\"' <>()\t\r\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			into[rest[:end]] = true
+			rest = rest[end:]
+		}
+	case []any:
+		for _, e := range x {
+			c.collectUrls(e, into)
+		}
+	case map[string]any:
+		for _, e := range x {
+			c.collectUrls(e, into)
+		}
+	case util.Mf2Document:
+		for _, values := range x.Properties {
+			c.collectUrls(values, into)
+		}
+	}
+}
+
+// isReferenced reports whether a post refers to the file, to any of its variants or to its poster.
+func isReferenced(entry mediaindex.Entry, referenced map[string]bool) bool {
+	if referenced[entry.Url] || (entry.Poster != "" && referenced[entry.Poster]) {
+		return true
+	}
+
+	return slices.ContainsFunc(entry.Variants, func(v mediaindex.Variant) bool {
+		return referenced[v.Url]
+	})
+}
+
+func isDeleted(doc util.Mf2Document) bool {
+	for _, v := range doc.Properties["deleted"] {
+		switch x := v.(type) {
+		case bool:
+			return x
+		case string:
+			return strings.EqualFold(x, "true")
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,276 @@
+package mediagc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+const mediaBase = "https://media.example.com/"
+
+// fakeContent serves docs in pages of perPage. Pages listed in unreadable fail as a store does when
+// it skips a row, and onList runs before each listing.
+type fakeContent struct {
+	content.Store
+	docs       []util.Mf2Document
+	perPage    int
+	unreadable map[int]bool
+	onList     func(page int)
+}
+
+func (f *fakeContent) List(_ context.Context, page int, _ int) ([]util.Mf2Document, error) {
+	if f.onList != nil {
+		f.onList(page)
+	}
+
+	start := min((page-1)*f.perPage, len(f.docs))
+	docs := f.docs[start:min(start+f.perPage, len(f.docs))]
+	if f.unreadable[page] {
+		return docs[:len(docs)-1], fmt.Errorf("%w: skipped 1 row", content.ErrUnreadable)
+	}
+
+	return docs, nil
+}
+
+type fakeMedia struct {
+	keys    []string
+	deleted []string
+}
+
+func (f *fakeMedia) Upload(context.Context, io.Reader, int64, string, string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (f *fakeMedia) Delete(_ context.Context, url string) error {
+	f.deleted = append(f.deleted, url)
+	return nil
+}
+
+func (f *fakeMedia) Stat(context.Context, string) (*media.ObjectInfo, error) {
+	return nil, media.ErrNotFound
+}
+
+func (f *fakeMedia) List(_ context.Context, opts media.ListOptions) ([]media.ObjectInfo, error) {
+	var out []media.ObjectInfo
+	for _, k := range slices.Sorted(slices.Values(f.keys)) {
+		if k > opts.After && len(out) < opts.Limit {
+			out = append(out, media.ObjectInfo{Key: k, Url: mediaBase + k, LastModified: time.Now().Add(-48 * time.Hour)})
+		}
+	}
+
+	return out, nil
+}
+
+func post(slug string, photos ...string) util.Mf2Document {
+	props := util.MicroformatProperties{"slug": {slug}}
+	for _, p := range photos {
+		props["photo"] = append(props["photo"], p)
+	}
+
+	return util.Mf2Document{Type: []string{"h-entry"}, Properties: props}
+}
+
+func newCollector(t *testing.T, store *fakeContent, files *fakeMedia, entries ...mediaindex.Entry) (*Collector, *mediaindex.Index) {
+	t.Helper()
+
+	index, err := mediaindex.Open("https://example.com/", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.Import(context.Background(), &fakeMedia{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded := time.Now().Add(-48 * time.Hour)
+	for _, e := range entries {
+		e.UploadedAt = uploaded
+		if err := index.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contentCfg := &config.Content{Pagination: config.Pagination{Enabled: true, PerPage: store.perPage}}
+	mediaCfg := &config.Media{PublicBaseUrl: mediaBase}
+	c := New("https://example.com/", &config.MediaGC{}, contentCfg, store, mediaCfg, files, index, events.NewBus("https://example.com/"))
+
+	return c, index
+}
+
+func TestRunDeletesOnlyOrphans(t *testing.T) {
+	store := &fakeContent{perPage: 2, docs: []util.Mf2Document{
+		post("a", mediaBase+"a.jpg"),
+		post("b"),
+		post("c", mediaBase+"c.jpg"),
+	}}
+	files := &fakeMedia{}
+	c, index := newCollector(t, store, files,
+		mediaindex.Entry{Url: mediaBase + "a.jpg"},
+		mediaindex.Entry{Url: mediaBase + "c.jpg"},
+		mediaindex.Entry{Url: mediaBase + "orphan.jpg"},
+	)
+
+	report, err := c.Run(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(report.Orphans, []string{mediaBase + "orphan.jpg"}) {
+		t.Errorf("orphans = %v", report.Orphans)
+	}
+	if !slices.Equal(files.deleted, []string{mediaBase + "orphan.jpg"}) {
+		t.Errorf("deleted = %v", files.deleted)
+	}
+	if _, ok := index.Get(mediaBase + "c.jpg"); !ok {
+		t.Error("referenced entry was removed from the index")
+	}
+}
+
+func TestRunScansPastShortPages(t *testing.T) {
+	store := &fakeContent{perPage: 3, docs: []util.Mf2Document{
+		post("a"),
+		post("b", mediaBase+"b.jpg"),
+	}}
+	files := &fakeMedia{}
+	c, _ := newCollector(t, store, files, mediaindex.Entry{Url: mediaBase + "b.jpg"})
+
+	// The store returns fewer documents than a page holds without having reached the end.
+	store.perPage = 1
+
+	report, err := c.Run(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Orphans) != 0 || len(files.deleted) != 0 {
+		t.Errorf("deleted media referenced from a later page: %v", files.deleted)
+	}
+}
+
+func TestRunAbortsOnUnreadableDocuments(t *testing.T) {
+	store := &fakeContent{perPage: 2, unreadable: map[int]bool{2: true}, docs: []util.Mf2Document{
+		post("a"),
+		post("b"),
+		post("c", mediaBase+"c.jpg"),
+	}}
+	files := &fakeMedia{}
+	c, _ := newCollector(t, store, files, mediaindex.Entry{Url: mediaBase + "c.jpg"})
+
+	if _, err := c.Run(context.Background(), false); !errors.Is(err, content.ErrUnreadable) {
+		t.Fatalf("err = %v, want ErrUnreadable", err)
+	}
+	if len(files.deleted) != 0 {
+		t.Errorf("deleted %v after an incomplete scan", files.deleted)
+	}
+}
+
+func TestRunAbortsWhenPostsChange(t *testing.T) {
+	store := &fakeContent{perPage: 1, docs: []util.Mf2Document{
+		post("a"),
+		post("b"),
+		post("c", mediaBase+"c.jpg"),
+	}}
+
+	// Renaming a post during the scan moves it ahead of the page being read, so it is never seen.
+	renamed := false
+	store.onList = func(page int) {
+		if page == 2 && !renamed {
+			renamed = true
+			store.docs = []util.Mf2Document{post("c2", mediaBase+"c.jpg"), post("a"), post("b")}
+		}
+	}
+
+	files := &fakeMedia{}
+	c, _ := newCollector(t, store, files, mediaindex.Entry{Url: mediaBase + "c.jpg"})
+
+	if _, err := c.Run(context.Background(), false); !errors.Is(err, ErrUnstableScan) {
+		t.Fatalf("err = %v, want ErrUnstableScan", err)
+	}
+	if len(files.deleted) != 0 {
+		t.Errorf("deleted %v after an unstable scan", files.deleted)
+	}
+}
+
+func TestRunKeepsDerivedFilesOfImportedMedia(t *testing.T) {
+	store := &fakeContent{perPage: 10, docs: []util.Mf2Document{
+		post("a", mediaBase+"kept.jpg"),
+	}}
+	files := &fakeMedia{keys: []string{
+		"kept.jpg", "kept-small.jpg", "kept-small.webp",
+		"clip.mp4", "clip-poster.jpg",
+	}}
+	c, index := newCollector(t, store, files)
+
+	// A rebuilt index learns of every object from the store.
+	if _, err := index.Import(context.Background(), files, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := c.Run(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(report.Orphans, []string{mediaBase + "clip.mp4"}) {
+		t.Errorf("orphans = %v", report.Orphans)
+	}
+	slices.Sort(files.deleted)
+	if want := []string{mediaBase + "clip-poster.jpg", mediaBase + "clip.mp4"}; !slices.Equal(files.deleted, want) {
+		t.Errorf("deleted = %v, want %v", files.deleted, want)
+	}
+}
+
+func TestReferenced(t *testing.T) {
+	store := &fakeContent{perPage: 1, docs: []util.Mf2Document{
+		post("a", mediaBase+"shared.jpg"),
+		post("b", mediaBase+"clip-poster.jpg"),
+		post("c", mediaBase+"gone.jpg"),
+	}}
+	store.docs[2].Properties["deleted"] = []any{true}
+
+	contentCfg := &config.Content{Pagination: config.Pagination{Enabled: true, PerPage: 1}}
+	mediaCfg := &config.Media{PublicBaseUrl: mediaBase}
+
+	tests := []struct {
+		entry mediaindex.Entry
+		want  bool
+	}{
+		{mediaindex.Entry{Url: mediaBase + "shared.jpg"}, true},
+		{mediaindex.Entry{Url: mediaBase + "clip.mp4", Poster: mediaBase + "clip-poster.jpg"}, true},
+		{mediaindex.Entry{Url: mediaBase + "gone.jpg"}, false},
+		{mediaindex.Entry{Url: mediaBase + "unused.jpg"}, false},
+	}
+
+	pages := 0
+	store.onList = func(int) { pages++ }
+
+	for _, tt := range tests {
+		got, err := Referenced(context.Background(), contentCfg, store, mediaCfg, tt.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Referenced(%s) = %v, want %v", tt.entry.Url, got, tt.want)
+		}
+	}
+
+	// Found on the first and second pages, then two full passes of three pages and the empty fourth.
+	if pages != 1+2+4+4 {
+		t.Errorf("listed %d pages, want %d", pages, 1+2+4+4)
+	}
+
+	store.unreadable = map[int]bool{2: true}
+	if _, err := Referenced(context.Background(), contentCfg, store, mediaCfg, tests[3].entry); !errors.Is(err, content.ErrUnreadable) {
+		t.Errorf("err = %v, want ErrUnreadable", err)
+	}
+}
@@ -0,0 +1,465 @@
+package mediaindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+// Variant is a derived rendition of an uploaded image.
+type Variant struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Type   string `json:"type"`
+}
+
+// Entry describes one uploaded media file.
+type Entry struct {
+	Url        string    `json:"url"`
+	Key        string    `json:"key"`
+	Hash       string    `json:"hash,omitempty"`
+	MimeType   string    `json:"mime_type,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	ClientId   string    `json:"client_id,omitempty"`
+	Alt        string    `json:"alt,omitempty"`
+	Variants   []Variant `json:"variants,omitempty"`
+
+	// Duration (in seconds), codecs, dimensions and a poster frame are recorded for audio and video
+	// when media analysis is enabled.
+	Duration   float64 `json:"duration,omitempty"`
+	VideoCodec string  `json:"video_codec,omitempty"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Poster     string  `json:"poster,omitempty"`
+}
+
+// ErrImporting is returned by List while the index is still being filled from the media store, when
+// a listing would be missing files uploaded before the index existed.
+var ErrImporting = errors.New("media index is still being imported")
+
+// minCompaction is how many journal records may accumulate, at the least, before they are folded
+// into the snapshot.
+const minCompaction = 1000
+
+// Index records what Scribble knows about a site's uploaded media, such as the variants generated
+// for each image. It is kept in memory and persisted under the data directory as a JSON snapshot
+// plus a journal of changes since, one JSON record per line, so each change costs a single append.
+// The journal is folded into the snapshot when the index is opened and once it outgrows it.
+type Index struct {
+	path        string
+	journalPath string
+	importPath  string
+
+	mu       sync.RWMutex
+	entries  map[string]*Entry
+	journal  *os.File
+	records  int
+	imported bool
+}
+
+// record is one line of the journal: an entry added or replaced, or the URL of one removed.
+type record struct {
+	Put    *Entry `json:"put,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+// Open loads the index for the site identified by me, creating an empty one if none exists yet.
+func Open(me string, dataDir string) (*Index, error) {
+	sum := sha256.Sum256([]byte(me))
+	dir := filepath.Join(dataDir, "media")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create media index directory: %w", err)
+	}
+
+	name := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+	idx := &Index{
+		path:        name + ".json",
+		journalPath: name + ".journal",
+		importPath:  name + ".imported",
+		entries:     make(map[string]*Entry),
+	}
+
+	if err := idx.load(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(idx.importPath); err == nil {
+		idx.imported = true
+	}
+
+	journal, err := os.OpenFile(idx.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open media index journal: %w", err)
+	}
+	idx.journal = journal
+
+	if err := idx.compact(); err != nil {
+		return nil, fmt.Errorf("failed to compact media index: %w", err)
+	}
+
+	return idx, nil
+}
+
+// load reads the snapshot and replays the journal over it. A partial last line, left by a crash
+// mid-write, is ignored.
+func (idx *Index) load() error {
+	data, err := os.ReadFile(idx.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read media index: %w", err)
+	}
+	if err == nil {
+		var entries []*Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("failed to parse media index %s: %w", idx.path, err)
+		}
+		for _, e := range entries {
+			idx.entries[e.Url] = e
+		}
+	}
+
+	data, err = os.ReadFile(idx.journalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read media index journal: %w", err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	if last := lines[len(lines)-1]; len(last) > 0 {
+		// Every record is written with its newline, so an unterminated line was cut short.
+		slog.Warn("ignoring incomplete last record in media index journal", "file", idx.journalPath)
+	}
+
+	for i, line := range lines[:len(lines)-1] {
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("failed to parse media index journal %s line %d: %w", idx.journalPath, i+1, err)
+		}
+
+		idx.apply(rec)
+	}
+
+	return nil
+}
+
+func (idx *Index) apply(rec record) {
+	switch {
+	case rec.Put != nil:
+		idx.entries[rec.Put.Url] = rec.Put
+	case rec.Delete != "":
+		delete(idx.entries, rec.Delete)
+	}
+}
+
+// Get returns a copy of the entry for url, if there is one.
+func (idx *Index) Get(url string) (Entry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	e, ok := idx.entries[url]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return *e, true
+}
+
+// Put adds or replaces the entry for e.Url and persists the index.
+func (idx *Index) Put(e Entry) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.entries[e.Url] = &e
+	return idx.append(record{Put: &e})
+}
+
+// Add records e unless an entry for e.Url already exists, reporting whether it was added.
+func (idx *Index) Add(e Entry) (bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.entries[e.Url]; ok {
+		return false, nil
+	}
+
+	idx.entries[e.Url] = &e
+	return true, idx.append(record{Put: &e})
+}
+
+// Update applies fn to the entry for url and persists the index. It returns false if there is no
+// such entry.
+func (idx *Index) Update(url string, fn func(*Entry)) (bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	e, ok := idx.entries[url]
+	if !ok {
+		return false, nil
+	}
+
+	fn(e)
+	return true, idx.append(record{Put: e})
+}
+
+// Delete removes an uploaded file, its variants and poster from the media store and forgets it. Files the
+// index does not know about are deleted from the store all the same.
+func (idx *Index) Delete(ctx context.Context, store media.Store, url string) error {
+	entry, known := idx.Get(url)
+
+	for _, v := range entry.Variants {
+		if err := store.Delete(ctx, v.Url); err != nil {
+			return fmt.Errorf("delete variant %s: %w", v.Url, err)
+		}
+	}
+
+	if entry.Poster != "" {
+		if err := store.Delete(ctx, entry.Poster); err != nil {
+			return fmt.Errorf("delete poster %s: %w", entry.Poster, err)
+		}
+	}
+
+	if err := store.Delete(ctx, url); err != nil {
+		return err
+	}
+
+	if !known {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.entries, url)
+	return idx.append(record{Delete: url})
+}
+
+// Len returns the number of recorded entries.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.entries)
+}
+
+// Imported reports whether the media store's existing files have been imported into the index.
+func (idx *Index) Imported() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.imported
+}
+
+// List returns up to limit entries, newest first, skipping the first offset. It fails with
+// ErrImporting until Import has completed once, since files uploaded before the index existed would
+// be missing.
+func (idx *Index) List(offset int, limit int) ([]Entry, error) {
+	idx.mu.RLock()
+	if !idx.imported {
+		idx.mu.RUnlock()
+		return nil, ErrImporting
+	}
+
+	entries := make([]Entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, *e)
+	}
+	idx.mu.RUnlock()
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if c := b.UploadedAt.Compare(a.UploadedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Url, b.Url)
+	})
+
+	if offset >= len(entries) {
+		return []Entry{}, nil
+	}
+
+	return entries[offset:min(offset+limit, len(entries))], nil
+}
+
+// Import records objects already in the media store, such as those uploaded before the index
+// existed. Only keys starting with prefix are considered, and existing entries are kept. It returns
+// the number of entries added.
+//
+// Variants and posters, stored as "<key without extension>-<name>.<ext>", are attached to the file
+// they were derived from rather than recorded as uploads of their own, so they are listed, kept and
+// deleted along with it. The store is listed in full before anything is recorded, and the index
+// lists nothing until an import has completed, so files uploaded meanwhile cannot be listed without
+// those that came before them. Completion is remembered across restarts.
+func (idx *Index) Import(ctx context.Context, store media.Store, prefix string) (int, error) {
+	const pageSize = 1000
+
+	var objects []media.ObjectInfo
+	for after := ""; ; {
+		page, err := store.List(ctx, media.ListOptions{Prefix: prefix, After: after, Limit: pageSize})
+		if err != nil {
+			return 0, err
+		}
+
+		objects = append(objects, page...)
+		if len(page) < pageSize {
+			break
+		}
+		after = page[len(page)-1].Key
+	}
+
+	// Index objects by their key without extension, so derived keys can find their source.
+	bases := make(map[string]media.ObjectInfo, len(objects))
+	for _, o := range objects {
+		base := strings.TrimSuffix(o.Key, path.Ext(o.Key))
+		if _, ok := bases[base]; !ok {
+			bases[base] = o
+		}
+	}
+
+	var derived []media.ObjectInfo
+	parents := make(map[string]string)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	added := 0
+	for _, o := range objects {
+		if parent, ok := derivedFrom(o.Key, bases); ok {
+			derived = append(derived, o)
+			parents[o.Url] = parent.Url
+			continue
+		}
+
+		if _, ok := idx.entries[o.Url]; ok {
+			continue
+		}
+		idx.entries[o.Url] = &Entry{
+			Url:        o.Url,
+			Key:        o.Key,
+			MimeType:   o.ContentType,
+			Size:       o.Size,
+			UploadedAt: o.LastModified.UTC(),
+		}
+		added++
+	}
+
+	for _, o := range derived {
+		parent, ok := idx.entries[parents[o.Url]]
+		if !ok {
+			continue
+		}
+
+		base := strings.TrimSuffix(o.Key, path.Ext(o.Key))
+		name := base[strings.LastIndex(base, "-")+1:]
+		switch {
+		case name == "poster" && path.Ext(o.Key) == ".jpg":
+			if parent.Poster == "" {
+				parent.Poster = o.Url
+			}
+		case !slices.ContainsFunc(parent.Variants, func(v Variant) bool { return v.Url == o.Url }):
+			parent.Variants = append(parent.Variants, Variant{Name: name, Url: o.Url, Type: o.ContentType})
+		}
+	}
+
+	if err := idx.compact(); err != nil {
+		return added, err
+	}
+
+	if err := os.WriteFile(idx.importPath, nil, 0o600); err != nil {
+		return added, fmt.Errorf("failed to record media import: %w", err)
+	}
+	idx.imported = true
+
+	return added, nil
+}
+
+// derivedFrom returns the object a key was derived from, if any: one whose key without extension is
+// the key's own without its last "-<name>" suffix.
+func derivedFrom(key string, bases map[string]media.ObjectInfo) (media.ObjectInfo, bool) {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	i := strings.LastIndex(base, "-")
+	if i <= 0 || strings.Contains(base[i:], "/") {
+		return media.ObjectInfo{}, false
+	}
+
+	parent, ok := bases[base[:i]]
+	if !ok || parent.Key == key {
+		return media.ObjectInfo{}, false
+	}
+
+	return parent, true
+}
+
+// append writes a record to the journal, folding the journal into the snapshot once it holds more
+// records than the snapshot has entries. It must be called with the lock held.
+func (idx *Index) append(rec record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := idx.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write media index journal: %w", err)
+	}
+
+	idx.records++
+	if idx.records > max(minCompaction, len(idx.entries)) {
+		return idx.compact()
+	}
+
+	return nil
+}
+
+// compact writes a snapshot of every entry and empties the journal. Replaying a journal over a
+// snapshot that already holds its records is harmless, so a crash between the two loses nothing.
+// It must be called with the lock held.
+func (idx *Index) compact() error {
+	if err := idx.save(); err != nil {
+		return err
+	}
+
+	if err := idx.journal.Truncate(0); err != nil {
+		return err
+	}
+	idx.records = 0
+
+	return nil
+}
+
+// save must be called with the lock held.
+func (idx *Index) save() error {
+	entries := make([]*Entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, e)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated index behind.
+	tmp := idx.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, idx.path)
+}
@@ -0,0 +1,229 @@
+package mediaindex
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+const base = "https://media.example.com/"
+
+// listStore lists a fixed set of keys in key order, a page at a time.
+type listStore struct {
+	keys []string
+}
+
+func (s listStore) Upload(context.Context, io.Reader, int64, string, string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s listStore) Delete(context.Context, string) error { return nil }
+
+func (s listStore) Stat(context.Context, string) (*media.ObjectInfo, error) {
+	return nil, media.ErrNotFound
+}
+
+func (s listStore) List(_ context.Context, opts media.ListOptions) ([]media.ObjectInfo, error) {
+	keys := slices.Sorted(slices.Values(s.keys))
+
+	var out []media.ObjectInfo
+	for _, k := range keys {
+		if !strings.HasPrefix(k, opts.Prefix) || k <= opts.After {
+			continue
+		}
+		if len(out) == opts.Limit {
+			break
+		}
+		out = append(out, media.ObjectInfo{Key: k, Url: base + k, LastModified: time.Now()})
+	}
+
+	return out, nil
+}
+
+func TestImportAttachesDerivedFiles(t *testing.T) {
+	idx, err := Open("https://example.com/", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := listStore{keys: []string{
+		"media/photo.jpg",
+		"media/photo-small.jpg",
+		"media/photo-small.webp",
+		"media/clip.mp4",
+		"media/clip-poster.jpg",
+		"media/2024-notes.pdf",
+		"other/skipped.jpg",
+	}}
+
+	added, err := idx.Import(context.Background(), store, "media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 {
+		t.Errorf("added = %d, want 3", added)
+	}
+
+	photo, ok := idx.Get(base + "media/photo.jpg")
+	if !ok {
+		t.Fatal("photo not imported")
+	}
+	var variants []string
+	for _, v := range photo.Variants {
+		if v.Name != "small" {
+			t.Errorf("variant %s named %q, want small", v.Url, v.Name)
+		}
+		variants = append(variants, v.Url)
+	}
+	slices.Sort(variants)
+	if want := []string{base + "media/photo-small.jpg", base + "media/photo-small.webp"}; !slices.Equal(variants, want) {
+		t.Errorf("variants = %v, want %v", variants, want)
+	}
+
+	clip, ok := idx.Get(base + "media/clip.mp4")
+	if !ok || clip.Poster != base+"media/clip-poster.jpg" {
+		t.Errorf("clip poster = %q", clip.Poster)
+	}
+
+	for _, key := range []string{"media/photo-small.jpg", "media/photo-small.webp", "media/clip-poster.jpg"} {
+		if _, ok := idx.Get(base + key); ok {
+			t.Errorf("%s recorded as an upload of its own", key)
+		}
+	}
+	if _, ok := idx.Get(base + "media/2024-notes.pdf"); !ok {
+		t.Error("file with a dash in its name but no source was not imported")
+	}
+}
+
+func TestImportPagesThroughStore(t *testing.T) {
+	idx, err := Open("https://example.com/", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for i := range 2500 {
+		keys = append(keys, "media/"+time.Unix(int64(i), 0).UTC().Format("150405")+".jpg")
+	}
+
+	added, err := idx.Import(context.Background(), listStore{keys: keys}, "media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2500 || idx.Len() != 2500 {
+		t.Errorf("added = %d, len = %d, want 2500", added, idx.Len())
+	}
+}
+
+func TestListWaitsForImport(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := Open("https://example.com/", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload arriving before the import has run must not make up the whole first page.
+	if err := idx.Put(Entry{Url: base + "media/new.jpg", UploadedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.List(0, 10); !errors.Is(err, ErrImporting) {
+		t.Fatalf("List before import: err = %v, want ErrImporting", err)
+	}
+
+	if _, err := idx.Import(context.Background(), listStore{keys: []string{"media/old.jpg"}}, "media/"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := idx.List(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("entries = %v, want the upload and the imported file", entries)
+	}
+
+	reopened, err := Open("https://example.com/", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Imported() {
+		t.Error("completed import was not remembered")
+	}
+}
+
+func TestJournalSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := Open("https://example.com/", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := idx.Put(Entry{Url: base + name + ".jpg"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := idx.Update(base+"b.jpg", func(e *Entry) { e.Alt = "A bee" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Delete(context.Background(), listStore{}, base+"c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes are appended to the journal rather than rewriting the snapshot.
+	if snapshot, err := os.ReadFile(idx.path); err != nil || string(snapshot) != "[]" {
+		t.Errorf("snapshot = %q, %v; want it untouched", snapshot, err)
+	}
+
+	// A crash mid-append leaves a partial record behind.
+	f, err := os.OpenFile(idx.journalPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"put":{"url":"` + base + `d.jp`)
+	f.Close()
+
+	reopened, err := Open("https://example.com/", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 2 {
+		t.Errorf("len = %d, want 2", reopened.Len())
+	}
+	if b, _ := reopened.Get(base + "b.jpg"); b.Alt != "A bee" {
+		t.Errorf("alt = %q", b.Alt)
+	}
+	if _, ok := reopened.Get(base + "c.jpg"); ok {
+		t.Error("deleted entry came back")
+	}
+	if journal, _ := os.ReadFile(reopened.journalPath); len(journal) != 0 {
+		t.Errorf("journal was not compacted on open: %q", journal)
+	}
+}
+
+func TestJournalCompacts(t *testing.T) {
+	idx, err := Open("https://example.com/", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range minCompaction + 1 {
+		if err := idx.Put(Entry{Url: base + "same.jpg", Size: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if journal, _ := os.ReadFile(idx.journalPath); len(journal) != 0 {
+		t.Errorf("journal holds %d bytes after compaction", len(journal))
+	}
+	if snapshot, _ := os.ReadFile(idx.path); !strings.Contains(string(snapshot), `"size":1000`) {
+		t.Errorf("snapshot = %s", snapshot)
+	}
+}
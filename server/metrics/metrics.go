@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+var (
+	requestsTotal = Default.NewCounterVec("scribble_requests_total",
+		"Micropub requests handled, by action and response status.", "action", "status")
+	requestDuration = Default.NewHistogramVec("scribble_request_duration_seconds",
+		"Time taken to handle Micropub requests, by action.", DefaultBuckets, "action")
+
+	tokenVerifications = Default.NewCounterVec("scribble_token_verifications_total",
+		"Access token verifications, by method (remote or jwt) and result (accepted, rejected or error).", "method", "result")
+	tokenEndpointDuration = Default.NewHistogramVec("scribble_token_endpoint_duration_seconds",
+		"Latency of calls to token endpoints.", DefaultBuckets, "endpoint")
+
+	contentStoreDuration = Default.NewHistogramVec("scribble_content_store_duration_seconds",
+		"Latency of content store calls, by strategy and method.", DefaultBuckets, "strategy", "method")
+	contentStoreErrors = Default.NewCounterVec("scribble_content_store_errors_total",
+		"Failed content store calls, by strategy and method.", "strategy", "method")
+
+	mediaStoreDuration = Default.NewHistogramVec("scribble_media_store_duration_seconds",
+		"Latency of media store calls, by strategy and method.", DefaultBuckets, "strategy", "method")
+	mediaStoreErrors = Default.NewCounterVec("scribble_media_store_errors_total",
+		"Failed media store calls, by strategy and method.", "strategy", "method")
+	mediaUploadBytes = Default.NewCounterVec("scribble_media_upload_bytes_total",
+		"Bytes successfully uploaded to media stores, by strategy.", "strategy")
+
+	_ = Default.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+)
+
+// Handler serves the default registry in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Render(w)
+	})
+}
+
+type actionKeyType struct{}
+
+var actionKey = actionKeyType{}
+
+// SetAction labels the current request with the Micropub action being performed (create, update,
+// q=config and so on). It is a no-op for requests that are not instrumented.
+func SetAction(ctx context.Context, action string) {
+	if holder, ok := ctx.Value(actionKey).(*string); ok {
+		*holder = action
+	}
+}
+
+// InstrumentHandler records the count and latency of requests to a route. The action label defaults to
+// the route name until a handler refines it with SetAction.
+func InstrumentHandler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		action := route
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), actionKey, &action)))
+
+		requestsTotal.Inc(action, strconv.Itoa(sw.status))
+		requestDuration.Observe(time.Since(start).Seconds(), action)
+	})
+}
+
+// ObserveTokenVerification records the outcome of verifying an access token.
+func ObserveTokenVerification(method string, result string) {
+	tokenVerifications.Inc(method, result)
+}
+
+// ObserveTokenEndpoint records the latency of a call to a token endpoint.
+func ObserveTokenEndpoint(endpoint string, elapsed time.Duration) {
+	tokenEndpointDuration.Observe(elapsed.Seconds(), endpoint)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
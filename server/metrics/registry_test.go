@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestRenderGolden(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.NewCounterVec("test_requests_total", "Requests handled.\nBy \"action\" and C:\\status.", "action", "status")
+	requests.Inc("create", "201")
+	requests.Inc("create", "201")
+	requests.Inc("update", "400")
+	requests.Add(2.5, `quote " backslash \ newline`+"\n"+`end`, "500")
+
+	latency := reg.NewHistogramVec("test_duration_seconds", "Time taken.", []float64{0.1, 0.5, 1}, "action")
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		latency.Observe(v, "create")
+	}
+	latency.Observe(0.75, "q=config")
+
+	reg.NewHistogramVec("test_unobserved_seconds", "Never observed.", []float64{1}, "action")
+	reg.NewGaugeFunc("test_goroutines", "Goroutines.", func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	reg.Render(&buf)
+
+	golden := "testdata/exposition.golden"
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("exposition output differs from %s:\n%s", golden, buf.String())
+	}
+}
+
+func TestHandlerContentType(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("# TYPE scribble_requests_total counter\n")) {
+		t.Errorf("body is missing the request counter:\n%s", rec.Body.String())
+	}
+}
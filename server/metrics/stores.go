@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+// InstrumentContentStore wraps a content store so the latency and failures of every call are recorded
+// under the given strategy name.
+func InstrumentContentStore(strategy string, store content.Store) content.Store {
+	return &contentStore{strategy: strategy, next: store}
+}
+
+// InstrumentMediaStore wraps a media store so the latency and failures of every call, and the bytes
+// uploaded, are recorded under the given strategy name.
+func InstrumentMediaStore(strategy string, store media.Store) media.Store {
+	return &mediaStore{strategy: strategy, next: store}
+}
+
+type contentStore struct {
+	strategy string
+	next     content.Store
+}
+
+func (s *contentStore) observe(method string, start time.Time, err error) {
+	contentStoreDuration.Observe(time.Since(start).Seconds(), s.strategy, method)
+	if err != nil {
+		contentStoreErrors.Inc(s.strategy, method)
+	}
+}
+
+func (s *contentStore) Create(ctx context.Context, doc util.Mf2Document) (url string, now bool, err error) {
+	defer func(start time.Time) { s.observe("create", start, err) }(time.Now())
+	return s.next.Create(ctx, doc)
+}
+
+func (s *contentStore) Update(ctx context.Context, url string, replacements map[string][]any, additions map[string][]any, deletions any) (newUrl string, err error) {
+	defer func(start time.Time) { s.observe("update", start, err) }(time.Now())
+	return s.next.Update(ctx, url, replacements, additions, deletions)
+}
+
+func (s *contentStore) Delete(ctx context.Context, url string) (newUrl string, err error) {
+	defer func(start time.Time) { s.observe("delete", start, err) }(time.Now())
+	return s.next.Delete(ctx, url)
+}
+
+func (s *contentStore) Undelete(ctx context.Context, url string) (newUrl string, err error) {
+	defer func(start time.Time) { s.observe("undelete", start, err) }(time.Now())
+	return s.next.Undelete(ctx, url)
+}
+
+func (s *contentStore) Get(ctx context.Context, url string) (doc *util.Mf2Document, err error) {
+	defer func(start time.Time) { s.observe("get", start, err) }(time.Now())
+	return s.next.Get(ctx, url)
+}
+
+func (s *contentStore) List(ctx context.Context, page int, limit int) (docs []util.Mf2Document, err error) {
+	defer func(start time.Time) { s.observe("list", start, err) }(time.Now())
+	return s.next.List(ctx, page, limit)
+}
+
+func (s *contentStore) ListCategories(ctx context.Context, page int, limit int, filter string) (categories []string, err error) {
+	defer func(start time.Time) { s.observe("list_categories", start, err) }(time.Now())
+	return s.next.ListCategories(ctx, page, limit, filter)
+}
+
+func (s *contentStore) ExistsBySlug(ctx context.Context, slug string) (exists bool, err error) {
+	defer func(start time.Time) { s.observe("exists_by_slug", start, err) }(time.Now())
+	return s.next.ExistsBySlug(ctx, slug)
+}
+
+// HealthCheck forwards to the wrapped store when it supports health checks.
+func (s *contentStore) HealthCheck(ctx context.Context) (err error) {
+	checker, ok := s.next.(content.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	defer func(start time.Time) { s.observe("health_check", start, err) }(time.Now())
+	return checker.HealthCheck(ctx)
+}
+
+type mediaStore struct {
+	strategy string
+	next     media.Store
+}
+
+func (s *mediaStore) observe(method string, start time.Time, err error) {
+	mediaStoreDuration.Observe(time.Since(start).Seconds(), s.strategy, method)
+	if err != nil {
+		mediaStoreErrors.Inc(s.strategy, method)
+	}
+}
+
+func (s *mediaStore) Upload(ctx context.Context, r io.Reader, size int64, contentType string, key string) (url string, err error) {
+	// Streamed uploads have no size up front, so count what passes through.
+	counter := &countingReader{r: r}
+	defer func(start time.Time) {
+		s.observe("upload", start, err)
+		if err == nil {
+			mediaUploadBytes.Add(float64(counter.n), s.strategy)
+		}
+	}(time.Now())
+	return s.next.Upload(ctx, counter, size, contentType, key)
+}
+
+func (s *mediaStore) Delete(ctx context.Context, url string) (err error) {
+	defer func(start time.Time) { s.observe("delete", start, err) }(time.Now())
+	return s.next.Delete(ctx, url)
+}
+
+func (s *mediaStore) Stat(ctx context.Context, key string) (info *media.ObjectInfo, err error) {
+	defer func(start time.Time) {
+		// A missing object is an answer, not a failure.
+		if errors.Is(err, media.ErrNotFound) {
+			s.observe("stat", start, nil)
+		} else {
+			s.observe("stat", start, err)
+		}
+	}(time.Now())
+	return s.next.Stat(ctx, key)
+}
+
+func (s *mediaStore) List(ctx context.Context, opts media.ListOptions) (objects []media.ObjectInfo, err error) {
+	defer func(start time.Time) { s.observe("list", start, err) }(time.Now())
+	return s.next.List(ctx, opts)
+}
+
+// HealthCheck forwards to the wrapped store when it supports health checks.
+func (s *mediaStore) HealthCheck(ctx context.Context) (err error) {
+	checker, ok := s.next.(media.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	defer func(start time.Time) { s.observe("health_check", start, err) }(time.Now())
+	return checker.HealthCheck(ctx)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,22 +1,23 @@
 package middleware
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
-	"github.com/indieinfra/scribble/config"
 	"github.com/indieinfra/scribble/server/auth"
 	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
 	"github.com/indieinfra/scribble/server/util"
 )
 
 // ValidateTokenMiddleware wraps a downstream handler. At execution time,
 // it extracts a Bearer token from the Authorization header, if any. If the Authorization
 // header is not present, or does not contain a Bearer token, it aborts the request.
-// If the token is present, it performs the VerifyAccessToken routine which makes a downstream
-// http request to the defined token endpoint to validate the token.
-func ValidateTokenMiddleware(cfg *config.Config, next http.Handler) http.Handler {
+// If the token is present, it performs the VerifyAccessToken routine which may make a downstream
+// http request to the token endpoint of the site the token belongs to. That site is attached to the
+// request context alongside the token details.
+func ValidateTokenMiddleware(st *state.ScribbleState, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := auth.ExtractBearerToken(r.Header.Get("Authorization"))
 
@@ -31,27 +32,19 @@ func ValidateTokenMiddleware(cfg *config.Config, next http.Handler) http.Handler
 			return
 		}
 
-		details, err := auth.VerifyAccessToken(cfg, token)
-		if err != nil {
-			log.Printf("error verifying access token: %v", err)
-			resp.WriteInternalServerError(w, "Failed to verify token")
-			return
-		}
-		if details == nil {
-			resp.WriteForbidden(w, "Token validation failed")
+		r, ok := authenticate(st, w, r, token)
+		if !ok {
 			return
 		}
 
-		rl := util.WithRequest(log.Default(), r, details.Me)
-		ctx := util.ContextWithLogger(r.Context(), rl)
-		next.ServeHTTP(w, r.WithContext(auth.AddToken(ctx, details)))
+		next.ServeHTTP(w, r)
 	})
 }
 
 // EnsureTokenForRequest attaches validated token details to the request context using the provided
 // token string when middleware has not already set them. It prefers existing context tokens and
 // returns an updated request pointer.
-func EnsureTokenForRequest(cfg *config.Config, w http.ResponseWriter, r *http.Request, token string) (*http.Request, bool) {
+func EnsureTokenForRequest(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, token string) (*http.Request, bool) {
 	if auth.GetToken(r.Context()) != nil {
 		return r, true
 	}
@@ -62,9 +55,15 @@ func EnsureTokenForRequest(cfg *config.Config, w http.ResponseWriter, r *http.Re
 		return nil, false
 	}
 
-	details, err := auth.VerifyAccessToken(cfg, token)
+	return authenticate(st, w, r, token)
+}
+
+// authenticate verifies the token, resolves the site it belongs to and attaches both to the request
+// context. Failures are written to the response and reported by returning false.
+func authenticate(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, token string) (*http.Request, bool) {
+	details, err := auth.VerifyAccessToken(r.Context(), st.Cfg, r.Host, token)
 	if err != nil {
-		log.Printf("error verifying access token: %v", err)
+		slog.ErrorContext(r.Context(), "error verifying access token", "error", err)
 		resp.WriteInternalServerError(w, "Failed to verify token")
 		return nil, false
 	}
@@ -73,7 +72,13 @@ func EnsureTokenForRequest(cfg *config.Config, w http.ResponseWriter, r *http.Re
 		return nil, false
 	}
 
-	rl := util.WithRequest(log.Default(), r, details.Me)
-	ctx := util.ContextWithLogger(r.Context(), rl)
+	site := st.Sites.ForMe(details.Me)
+	if site == nil {
+		resp.WriteForbidden(w, "Token does not belong to a site hosted here")
+		return nil, false
+	}
+
+	ctx := util.ContextWithLogAttrs(r.Context(), slog.String("me", details.Me), slog.String("client_id", details.ClientId))
+	ctx = state.AddSite(ctx, site)
 	return r.WithContext(auth.AddToken(ctx, details)), true
 }
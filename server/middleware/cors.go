@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+// corsAllowedHeaders are the request headers browser clients need to talk Micropub, and tus for
+// resumable uploads.
+var corsAllowedHeaders = []string{"Authorization", "Content-Type", "Accept", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"}
+
+// corsExposedHeaders are the response headers browser clients may read. Location carries the URL of
+// created posts and uploads; the tus headers report resumable upload progress.
+var corsExposedHeaders = []string{"Location", "Retry-After", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires"}
+
+// CorsMiddleware adds CORS headers to responses for requests from allowed origins, so browser clients
+// on other origins can read them.
+func CorsMiddleware(cfg *config.Cors, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if writeCorsOrigin(cfg, w, r) {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CorsPreflightHandler answers OPTIONS requests for a route that supports the given methods. CORS
+// preflights from allowed origins are granted; other origins receive no CORS headers and are refused
+// by the browser.
+func CorsPreflightHandler(cfg *config.Cors, methods ...string) http.Handler {
+	allowed := strings.Join(append(slices.Clone(methods), http.MethodOptions), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allowed)
+
+		requested := r.Header.Get("Access-Control-Request-Method")
+		if requested != "" && slices.Contains(methods, requested) && writeCorsOrigin(cfg, w, r) {
+			w.Header().Set("Access-Control-Allow-Methods", allowed)
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// writeCorsOrigin sets the allow-origin headers when the request's origin is allowed, and reports
+// whether it did.
+func writeCorsOrigin(cfg *config.Cors, w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(cfg.AllowedOrigins) == 0 {
+		return false
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	wildcard := slices.Contains(cfg.AllowedOrigins, "*")
+	if !wildcard && !slices.ContainsFunc(cfg.AllowedOrigins, func(o string) bool {
+		return strings.EqualFold(strings.TrimSuffix(o, "/"), origin)
+	}) {
+		return false
+	}
+
+	// The wildcard is never combined with credentials; configuration loading rejects it.
+	if wildcard {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/indieinfra/scribble/server/util"
+)
+
+// RequestIdHeader carries the request ID. A well-formed ID sent by the client (or a proxy) is kept;
+// otherwise a new one is generated. It is always echoed in the response.
+const RequestIdHeader = "X-Request-ID"
+
+const maxRequestIdLength = 128
+
+// RequestLogMiddleware assigns every request an ID, attaches it (with the method and path) to all log
+// records produced while handling the request, and writes an access log line once it completes.
+func RequestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIdHeader)
+		if !validRequestId(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIdHeader, id)
+
+		ctx := util.ContextWithRequestId(r.Context(), id)
+		ctx = util.ContextWithLogAttrs(ctx, slog.String("method", r.Method), slog.String("path", r.URL.Path))
+
+		aw := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(aw, r.WithContext(ctx))
+
+		slog.LogAttrs(ctx, slog.LevelInfo, "request completed",
+			slog.Int("status", aw.status),
+			slog.Int64("bytes", aw.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// accessWriter records the status and size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (aw *accessWriter) WriteHeader(status int) {
+	if !aw.wroteHeader {
+		aw.status = status
+		aw.wroteHeader = true
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessWriter) Write(b []byte) (int, error) {
+	aw.wroteHeader = true
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
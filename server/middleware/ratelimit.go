@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/ratelimit"
+	"github.com/indieinfra/scribble/server/resp"
+	"github.com/indieinfra/scribble/server/state"
+)
+
+// unlimitedPaths are polled by probes and scrapers, often from a single address, and are never charged
+// against the request budget so a busy client cannot make an instance look unhealthy.
+var unlimitedPaths = []string{"/healthz", "/readyz", "/metrics"}
+
+// RateLimitMiddleware charges every request against the per-IP request budget before any other work
+// (such as token verification) is done. It is a no-op when rate limiting is disabled.
+func RateLimitMiddleware(st *state.ScribbleState, next http.Handler) http.Handler {
+	if st.RateLimiter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(unlimitedPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := st.RateLimiter.Allow(ratelimit.ClassRequest, "ip:"+st.RateLimiter.ClientIP(r))
+		if !ok {
+			resp.WriteTooManyRequests(w, wait, "Too many requests, slow down")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireBudget charges an authenticated request against the budget for the given class, keyed by the
+// token, its client_id and the remote IP. When any of them is exhausted it writes a 429 response and
+// returns false.
+func RequireBudget(st *state.ScribbleState, w http.ResponseWriter, r *http.Request, class ratelimit.Class) bool {
+	if st.RateLimiter == nil {
+		return true
+	}
+
+	keys := []string{"ip:" + st.RateLimiter.ClientIP(r)}
+	if token := auth.GetToken(r.Context()); token != nil {
+		keys = append(keys, "token:"+token.Hash)
+		if token.ClientId != "" {
+			keys = append(keys, "client:"+token.ClientId)
+		}
+	}
+
+	ok, wait := st.RateLimiter.Allow(class, keys...)
+	if !ok {
+		resp.WriteTooManyRequests(w, wait, "Too many "+class.String()+" requests, slow down")
+		return false
+	}
+
+	return true
+}
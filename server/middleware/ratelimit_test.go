@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/ratelimit"
+	"github.com/indieinfra/scribble/server/state"
+)
+
+func TestRateLimitMiddlewareExemptsProbes(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(&config.RateLimit{Enabled: true, Requests: config.RateBudget{Limit: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RateLimitMiddleware(&state.ScribbleState{RateLimiter: limiter}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := get("/"); code != http.StatusOK {
+		t.Fatalf("first request = %d", code)
+	}
+	if code := get("/"); code != http.StatusTooManyRequests {
+		t.Fatalf("request over budget = %d, want 429", code)
+	}
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		for range 3 {
+			if code := get(path); code != http.StatusOK {
+				t.Errorf("%s = %d after the budget ran out", path, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+// Class identifies a separately budgeted kind of request.
+type Class int
+
+const (
+	ClassRequest Class = iota
+	ClassCreate
+	ClassUpdate
+	ClassMedia
+)
+
+var className = map[Class]string{
+	ClassRequest: "request",
+	ClassCreate:  "create",
+	ClassUpdate:  "update",
+	ClassMedia:   "media",
+}
+
+func (c Class) String() string {
+	return className[c]
+}
+
+// sweepInterval is how often idle buckets are dropped from memory.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type budget struct {
+	rate  float64 // tokens per second
+	burst float64
+}
+
+// Limiter enforces token-bucket budgets per request class. Each class keeps independent buckets for
+// every key (token hash, client_id, remote IP) it is asked about.
+type Limiter struct {
+	budgets   map[Class]budget
+	trusted   []*net.IPNet
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewLimiter(cfg *config.RateLimit) (*Limiter, error) {
+	l := &Limiter{
+		budgets: map[Class]budget{},
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+
+	for _, raw := range cfg.TrustedProxies {
+		network, err := parseNetwork(raw)
+		if err != nil {
+			return nil, err
+		}
+		l.trusted = append(l.trusted, network)
+	}
+
+	configured := map[Class]config.RateBudget{
+		ClassRequest: cfg.Requests,
+		ClassCreate:  cfg.Create,
+		ClassUpdate:  cfg.Update,
+		ClassMedia:   cfg.Media,
+	}
+
+	for class, b := range configured {
+		if b.Limit <= 0 {
+			continue
+		}
+
+		per := b.Per
+		if per <= 0 {
+			per = time.Minute
+		}
+
+		burst := b.Burst
+		if burst <= 0 {
+			burst = b.Limit
+		}
+
+		l.budgets[class] = budget{rate: float64(b.Limit) / per.Seconds(), burst: float64(burst)}
+	}
+
+	return l, nil
+}
+
+func parseNetwork(raw string) (*net.IPNet, error) {
+	if !strings.Contains(raw, "/") {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", raw)
+		}
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %q: %w", raw, err)
+	}
+	return network, nil
+}
+
+// Allow takes one token from the class's bucket for each key. It returns true if every bucket had a
+// token available; otherwise nothing is taken and the time until all buckets can serve the request is
+// returned. Empty keys are ignored, and classes without a configured budget are always allowed.
+func (l *Limiter) Allow(class Class, keys ...string) (bool, time.Duration) {
+	b, ok := l.budgets[class]
+	if !ok {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	var pending []*bucket
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		id := class.String() + "|" + key
+		bk, ok := l.buckets[id]
+		if !ok {
+			bk = &bucket{tokens: b.burst, updated: now}
+			l.buckets[id] = bk
+		}
+
+		bk.tokens = math.Min(b.burst, bk.tokens+now.Sub(bk.updated).Seconds()*b.rate)
+		bk.updated = now
+
+		if bk.tokens < 1 {
+			needed := time.Duration((1 - bk.tokens) / b.rate * float64(time.Second))
+			wait = max(wait, needed)
+			continue
+		}
+
+		pending = append(pending, bk)
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, bk := range pending {
+		bk.tokens--
+	}
+
+	return true, 0
+}
+
+// sweep drops buckets that have been idle long enough to be full again; they are indistinguishable
+// from fresh ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for id, bk := range l.buckets {
+		class := classOf(id)
+		b, ok := l.budgets[class]
+		if !ok || bk.tokens+now.Sub(bk.updated).Seconds()*b.rate >= b.burst {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+func classOf(id string) Class {
+	name, _, _ := strings.Cut(id, "|")
+	for class, n := range className {
+		if n == name {
+			return class
+		}
+	}
+	return ClassRequest
+}
+
+// ClientIP returns the address of the client that made the request. X-Forwarded-For is only honoured
+// when the direct peer is a trusted proxy; the header is then walked from the right, skipping trusted
+// proxies, so clients cannot spoof their address by adding entries of their own.
+func (l *Limiter) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !l.isTrusted(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !l.isTrusted(hop) {
+			return hop
+		}
+		host = hop
+	}
+
+	return host
+}
+
+func (l *Limiter) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,7 +3,10 @@ package resp
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type ErrorResponse struct {
@@ -63,10 +66,44 @@ func WriteInternalServerError(w http.ResponseWriter, description string) {
 	writeError(w, http.StatusInternalServerError, "internal_server_error", description)
 }
 
+// WriteServiceUnavailable reports that the server cannot currently serve requests, with an
+// optional body describing why.
+func WriteServiceUnavailable(w http.ResponseWriter, object any) {
+	writeResp(w, http.StatusServiceUnavailable, object)
+}
+
 func WriteNotFound(w http.ResponseWriter, description string) {
 	writeError(w, http.StatusNotFound, "not_found", description)
 }
 
+// WriteTooManyRequests rejects a request that exceeded its rate limit, advising the client when it may
+// retry via the Retry-After header.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, description string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "too_many_requests", description)
+}
+
+// WriteConflict rejects a request that does not match the current state of the resource, such as a
+// resumable upload chunk sent for the wrong offset.
+func WriteConflict(w http.ResponseWriter, description string) {
+	writeError(w, http.StatusConflict, "conflict", description)
+}
+
+// WritePreconditionFailed rejects a request made with an unsupported protocol version.
+func WritePreconditionFailed(w http.ResponseWriter, description string) {
+	writeError(w, http.StatusPreconditionFailed, "precondition_failed", description)
+}
+
+// WriteTooLarge rejects a request whose content exceeds the size the server accepts.
+func WriteTooLarge(w http.ResponseWriter, description string) {
+	writeError(w, http.StatusRequestEntityTooLarge, "too_large", description)
+}
+
+// WriteUnsupportedMediaType rejects a request body sent with the wrong Content-Type.
+func WriteUnsupportedMediaType(w http.ResponseWriter, description string) {
+	writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", description)
+}
+
 func writeError(w http.ResponseWriter, status int, err string, description string) {
 	writeResp(w, status, ErrorResponse{
 		Error:       err,
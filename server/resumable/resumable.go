@@ -0,0 +1,326 @@
+package resumable
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultExpiry = 24 * time.Hour
+
+var (
+	// ErrNotFound indicates that no upload exists with an ID, or that it has expired.
+	ErrNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch indicates that a chunk does not continue from where the upload stands.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrTooLong indicates that a chunk would take the upload past its declared length.
+	ErrTooLong = errors.New("chunk exceeds upload length")
+	// ErrBusy indicates that another chunk is being written to the upload.
+	ErrBusy = errors.New("upload is busy")
+)
+
+// Upload describes a resumable upload in progress, or one finished into the media store.
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	ClientId  string            `json:"client_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	// Checked records that the upload's type has been verified from its first bytes.
+	Checked bool `json:"checked,omitempty"`
+	// Url is the stored file's public URL once the upload is finished.
+	Url string `json:"url,omitempty"`
+}
+
+// Complete reports whether every byte of the upload has been received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Store stages resumable uploads for a site on local disk until they are complete. Each upload is
+// a data file and a JSON record under the data directory; both are removed once the upload expires.
+type Store struct {
+	dir    string
+	expiry time.Duration
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// Open prepares the staging area for the site identified by me. Uploads not finished within expiry
+// (default 24h) are discarded.
+func Open(me string, dataDir string, expiry time.Duration) (*Store, error) {
+	sum := sha256.Sum256([]byte(me))
+	dir := filepath.Join(dataDir, "uploads", hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create upload staging directory: %w", err)
+	}
+
+	if expiry == 0 {
+		expiry = defaultExpiry
+	}
+
+	return &Store{dir: dir, expiry: expiry, busy: make(map[string]bool)}, nil
+}
+
+// Expiry returns how long an upload may take before it is discarded.
+func (s *Store) Expiry() time.Duration {
+	return s.expiry
+}
+
+// Create starts an upload of length bytes.
+func (s *Store) Create(length int64, metadata map[string]string, clientId string) (*Upload, error) {
+	u := &Upload{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Metadata:  metadata,
+		ClientId:  clientId,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	f, err := os.OpenFile(s.dataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	if err := s.save(u); err != nil {
+		os.Remove(s.dataPath(u.ID))
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Get returns the upload with the given ID.
+func (s *Store) Get(id string) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(s.recordPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("failed to parse upload record %s: %w", id, err)
+	}
+
+	if time.Since(u.CreatedAt) > s.expiry {
+		return nil, ErrNotFound
+	}
+
+	return &u, nil
+}
+
+// Append writes a chunk read from r to the upload, which must stand at offset. Bytes received before
+// r fails are kept, so a client can resume from wherever the connection dropped; the returned upload
+// reflects them even when an error is returned.
+func (s *Store) Append(id string, offset int64, r io.Reader) (*Upload, error) {
+	if !s.acquire(id) {
+		return nil, ErrBusy
+	}
+	defer s.release(id)
+
+	u, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Url != "" || offset != u.Offset {
+		return u, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0o600)
+	if err != nil {
+		return u, err
+	}
+	defer f.Close()
+
+	// Discard anything past the recorded offset, left by a write that failed before it was recorded.
+	if err := f.Truncate(u.Offset); err != nil {
+		return u, err
+	}
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		return u, err
+	}
+
+	// Read one byte past the remaining length to tell a chunk that is too long, which is discarded
+	// whole.
+	n, copyErr := io.Copy(f, io.LimitReader(r, u.Length-u.Offset+1))
+	if u.Offset+n > u.Length {
+		if err := f.Truncate(u.Offset); err != nil {
+			return u, err
+		}
+		return u, ErrTooLong
+	}
+
+	if err := f.Sync(); err != nil {
+		return u, err
+	}
+
+	u.Offset += n
+	if err := s.save(u); err != nil {
+		return u, err
+	}
+
+	return u, copyErr
+}
+
+// Update applies fn to the upload's record and saves it.
+func (s *Store) Update(id string, fn func(*Upload)) (*Upload, error) {
+	u, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	fn(u)
+	return u, s.save(u)
+}
+
+// Acquire claims an upload for an operation that must not overlap a chunk being written, such as
+// finishing it, reporting false if it is busy. Release must be called afterwards.
+func (s *Store) Acquire(id string) bool {
+	return s.acquire(id)
+}
+
+// Release ends an operation begun with Acquire.
+func (s *Store) Release(id string) {
+	s.release(id)
+}
+
+// Data opens the bytes received for an upload.
+func (s *Store) Data(id string) (*os.File, error) {
+	return os.Open(s.dataPath(id))
+}
+
+// Finish records the URL an upload was stored at and discards its staged data. The record is kept
+// until the upload expires, so a client whose last response was lost can still learn the URL.
+func (s *Store) Finish(id string, url string) error {
+	if _, err := s.Update(id, func(u *Upload) { u.Url = url }); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Remove discards an upload.
+func (s *Store) Remove(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+
+	if !s.acquire(id) {
+		return ErrBusy
+	}
+	defer s.release(id)
+
+	return s.remove(id)
+}
+
+// Sweep discards expired uploads, returning how many there were.
+func (s *Store) Sweep() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		if _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		if !s.acquire(id) {
+			continue
+		}
+		err := s.remove(id)
+		s.release(id)
+		if err != nil {
+			slog.Warn("failed to remove expired upload", "id", id, "error", err)
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (s *Store) remove(id string) error {
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Remove(s.recordPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) acquire(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *Store) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.busy, id)
+}
+
+func (s *Store) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated record behind.
+	tmp := s.recordPath(u.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.recordPath(u.ID))
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+func (s *Store) recordPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,11 +12,27 @@ import (
 	"time"
 
 	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/analyze"
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/build"
+	"github.com/indieinfra/scribble/server/enrich"
+	"github.com/indieinfra/scribble/server/events"
 	"github.com/indieinfra/scribble/server/handler/get"
+	"github.com/indieinfra/scribble/server/handler/health"
 	"github.com/indieinfra/scribble/server/handler/post"
 	"github.com/indieinfra/scribble/server/handler/upload"
+	"github.com/indieinfra/scribble/server/imaging"
+	"github.com/indieinfra/scribble/server/mediagc"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/metrics"
 	"github.com/indieinfra/scribble/server/middleware"
+	"github.com/indieinfra/scribble/server/ratelimit"
+	"github.com/indieinfra/scribble/server/resumable"
 	"github.com/indieinfra/scribble/server/state"
+	"github.com/indieinfra/scribble/server/tracing"
+	"github.com/indieinfra/scribble/server/webhook"
+	"github.com/indieinfra/scribble/server/webmention"
+	"github.com/indieinfra/scribble/server/websub"
 	"github.com/indieinfra/scribble/storage/content"
 	"github.com/indieinfra/scribble/storage/content/factory"
 	"github.com/indieinfra/scribble/storage/media"
@@ -24,28 +40,50 @@ import (
 	"github.com/indieinfra/scribble/storage/util"
 )
 
+// importRetry is how long to wait before retrying a failed import of existing media.
+const importRetry = time.Minute
+
 func StartServer(cfg *config.Config) error {
-	log.Println("initializing...")
+	slog.Info("initializing...")
+	if err := tracing.Setup(&cfg.Tracing); err != nil {
+		return fmt.Errorf("tracing setup failed: %w", err)
+	}
+
 	st, err := initialize(&state.ScribbleState{Cfg: cfg})
 	if err != nil {
 		return fmt.Errorf("initialization failed: %w", err)
 	}
 
-	log.Println("configuring routes...")
+	slog.Info("configuring routes...")
 	mux := http.NewServeMux()
-	mux.Handle("GET /", middleware.ValidateTokenMiddleware(st.Cfg, get.DispatchGet(st)))
-	mux.Handle("POST /", middleware.ValidateTokenMiddleware(st.Cfg, post.DispatchPost(st)))
-	mux.Handle("POST /media", middleware.ValidateTokenMiddleware(st.Cfg, upload.HandleMediaUpload(st)))
+	mux.Handle("GET /", metrics.InstrumentHandler("get", middleware.ValidateTokenMiddleware(st, get.DispatchGet(st))))
+	mux.Handle("POST /", metrics.InstrumentHandler("post", middleware.ValidateTokenMiddleware(st, post.DispatchPost(st))))
+	mux.Handle("POST /media", metrics.InstrumentHandler("media", middleware.ValidateTokenMiddleware(st, upload.DispatchMediaPost(st))))
+	mux.Handle("GET /media", metrics.InstrumentHandler("media", middleware.ValidateTokenMiddleware(st, upload.DispatchMediaGet(st))))
+	mux.Handle("POST /media/uploads", metrics.InstrumentHandler("media_upload", middleware.ValidateTokenMiddleware(st, upload.HandleResumableCreate(st))))
+	mux.Handle("HEAD /media/uploads/{id}", metrics.InstrumentHandler("media_upload", middleware.ValidateTokenMiddleware(st, upload.HandleResumableHead(st))))
+	mux.Handle("PATCH /media/uploads/{id}", metrics.InstrumentHandler("media_upload", middleware.ValidateTokenMiddleware(st, upload.HandleResumablePatch(st))))
+	mux.Handle("DELETE /media/uploads/{id}", metrics.InstrumentHandler("media_upload", middleware.ValidateTokenMiddleware(st, upload.HandleResumableDelete(st))))
+	mux.Handle("GET /healthz", health.HandleHealthz())
+	mux.Handle("GET /readyz", health.HandleReadyz(st))
+	mux.Handle("GET /version", health.HandleVersion())
+	if st.Cfg.Server.Metrics.Enabled {
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+	mux.Handle("OPTIONS /", middleware.CorsPreflightHandler(&st.Cfg.Server.Cors, http.MethodGet, http.MethodPost))
+	mux.Handle("OPTIONS /media", middleware.CorsPreflightHandler(&st.Cfg.Server.Cors, http.MethodGet, http.MethodPost))
+	mux.Handle("OPTIONS /media/uploads", upload.HandleResumableOptions(st, http.MethodPost))
+	mux.Handle("OPTIONS /media/uploads/{id}", upload.HandleResumableOptions(st, http.MethodHead, http.MethodPatch, http.MethodDelete))
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf("%v:%v", st.Cfg.Server.Address, st.Cfg.Server.Port),
-		Handler: mux,
+		Handler: middleware.RequestLogMiddleware(tracing.Middleware(middleware.CorsMiddleware(&st.Cfg.Server.Cors, middleware.RateLimitMiddleware(st, mux)))),
 	}
 
 	// Start serving in background to support graceful shutdown.
 	errChan := make(chan error, 1)
 	go func() {
-		log.Printf("serving http requests on %q", srv.Addr)
+		slog.Info("serving http requests", "address", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
@@ -57,11 +95,24 @@ func StartServer(cfg *config.Config) error {
 
 	select {
 	case sig := <-sigChan:
-		log.Printf("received signal %v, shutting down...", sig)
+		slog.Info("received signal, shutting down...", "signal", sig)
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("graceful shutdown failed: %v", err)
+			slog.Error("graceful shutdown failed", "error", err)
+		}
+		for _, site := range st.Sites.All() {
+			if err := site.Events.Close(ctx); err != nil {
+				slog.Error("failed to stop event subscribers", "me", site.Me(), "error", err)
+			}
+			if site.MediaGC != nil {
+				if err := site.MediaGC.Close(ctx); err != nil {
+					slog.Error("failed to stop media collection", "me", site.Me(), "error", err)
+				}
+			}
+		}
+		if err := tracing.Shutdown(ctx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
 		}
 		return nil
 	case err := <-errChan:
@@ -70,22 +121,132 @@ func StartServer(cfg *config.Config) error {
 }
 
 func initialize(st *state.ScribbleState) (*state.ScribbleState, error) {
-	st.ContentPathPattern = util.NewPathPattern(st.Cfg.Content.ContentPathPattern)
-	st.MediaPathPattern = util.NewPathPattern(st.Cfg.Media.MediaPathPattern)
+	st.Sites = state.NewSiteRegistry()
+
+	dataDir := st.Cfg.Server.DataDir
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	for _, siteCfg := range st.Cfg.AllSites() {
+		site, err := initializeSite(&siteCfg, dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("site %q: %w", siteCfg.Micropub.MeUrl, err)
+		}
+
+		slog.Info("hosting site", "me", site.Me())
+		st.Sites.Add(site)
+	}
 
-	contentStore, err := initializeContentStore(&st.Cfg.Content)
+	if st.Cfg.Server.RateLimit.Enabled {
+		limiter, err := ratelimit.NewLimiter(&st.Cfg.Server.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		st.RateLimiter = limiter
+	}
+
+	return st, nil
+}
+
+func initializeSite(cfg *config.Site, dataDir string) (*state.Site, error) {
+	site := &state.Site{
+		Micropub:           &cfg.Micropub,
+		Content:            &cfg.Content,
+		Media:              &cfg.Media,
+		ContentPathPattern: util.NewPathPattern(cfg.Content.ContentPathPattern),
+		MediaPathPattern:   util.NewPathPattern(cfg.Media.MediaPathPattern),
+		ScopePolicy:        auth.NewScopePolicy(&cfg.Micropub.ScopePolicy),
+		Events:             events.NewBus(cfg.Micropub.MeUrl),
+	}
+
+	contentStore, err := initializeContentStore(site.Content)
 	if err != nil {
 		return nil, err
 	}
-	st.ContentStore = contentStore
+	site.ContentStore = metrics.InstrumentContentStore(cfg.Content.Strategy, tracing.TraceContentStore(cfg.Content.Strategy, contentStore))
 
-	mediaStore, err := initializeMediaStore(&st.Cfg.Media)
+	mediaStore, err := initializeMediaStore(site.Media)
 	if err != nil {
 		return nil, err
 	}
-	st.MediaStore = mediaStore
+	site.MediaStore = metrics.InstrumentMediaStore(cfg.Media.Strategy, tracing.TraceMediaStore(cfg.Media.Strategy, mediaStore))
 
-	return st, nil
+	mediaIndex, err := mediaindex.Open(site.Me(), dataDir)
+	if err != nil {
+		return nil, err
+	}
+	site.MediaIndex = mediaIndex
+
+	if !mediaIndex.Imported() {
+		// Pick up media uploaded before the index existed, without holding up startup. The index
+		// refuses listings until this succeeds, so it is retried until it does.
+		go func() {
+			for {
+				added, err := mediaIndex.Import(context.Background(), site.MediaStore, site.MediaPathPattern.Prefix())
+				if err == nil {
+					slog.Info("imported existing media into index", "me", site.Me(), "count", added)
+					return
+				}
+				slog.Error("failed to import existing media into index, will retry", "me", site.Me(), "retry_in", importRetry, "error", err)
+				time.Sleep(importRetry)
+			}
+		}()
+	}
+
+	if cfg.Media.Images.Enabled {
+		site.Images = imaging.NewProcessor(&cfg.Media.Images)
+	}
+
+	if cfg.Media.Analysis.Enabled {
+		// Analysis depends on local binaries, so a host without them still serves uploads.
+		analyzer, err := analyze.NewFFmpeg(&cfg.Media.Analysis)
+		if err != nil {
+			slog.Warn("media analysis disabled", "me", site.Me(), "error", err)
+		} else {
+			site.Analyzer = analyzer
+		}
+	}
+
+	if cfg.Media.Resumable.Enabled {
+		uploads, err := resumable.Open(site.Me(), dataDir, cfg.Media.Resumable.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		site.Uploads = uploads
+	}
+
+	if cfg.Media.GC.Enabled {
+		site.MediaGC = mediagc.New(site.Me(), &cfg.Media.GC, site.Content, site.ContentStore, site.Media, site.MediaStore, site.MediaIndex, site.Events)
+		site.MediaGC.Start()
+	}
+
+	if cfg.Enrich.ReplyContext.Enabled || cfg.Enrich.LinkPreview.Enabled {
+		site.Enricher = enrich.New(&cfg.Enrich, site.MediaStore, site.MediaPathPattern)
+	}
+
+	if len(cfg.Hooks.Webhooks) > 0 {
+		dispatcher, err := webhook.NewDispatcher(site.Me(), cfg.Hooks.Webhooks, dataDir)
+		if err != nil {
+			return nil, err
+		}
+		site.Events.Subscribe(dispatcher)
+	}
+
+	if len(cfg.Hooks.Build.Command) > 0 {
+		site.Builder = build.NewRunner(site.Me(), &cfg.Hooks.Build)
+		site.Events.Subscribe(site.Builder)
+	}
+
+	if cfg.Hooks.Webmention.Enabled {
+		site.Events.Subscribe(webmention.NewSender(site.Me(), site.ContentStore, &cfg.Hooks.Webmention))
+	}
+
+	if cfg.Hooks.WebSub.Hub != "" {
+		site.Events.Subscribe(websub.NewPublisher(site.Me(), &cfg.Hooks.WebSub))
+	}
+
+	return site, nil
 }
 
 func initializeContentStore(cfg *config.Content) (content.Store, error) {
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"context"
+	"strings"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/analyze"
+	"github.com/indieinfra/scribble/server/auth"
+	"github.com/indieinfra/scribble/server/build"
+	"github.com/indieinfra/scribble/server/enrich"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/imaging"
+	"github.com/indieinfra/scribble/server/mediagc"
+	"github.com/indieinfra/scribble/server/mediaindex"
+	"github.com/indieinfra/scribble/server/resumable"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+	"github.com/indieinfra/scribble/storage/util"
+)
+
+type siteKeyType struct{}
+
+var siteKey = siteKeyType{}
+
+// Site holds the configuration and storage backends for a single site hosted by this instance.
+type Site struct {
+	Micropub           *config.Micropub
+	Content            *config.Content
+	Media              *config.Media
+	ContentPathPattern *util.PathPattern
+	MediaPathPattern   *util.PathPattern
+	ContentStore       content.Store
+	MediaStore         media.Store
+	ScopePolicy        *auth.ScopePolicy
+	Events             *events.Bus
+	Builder            *build.Runner
+	Enricher           *enrich.Enricher
+	Images             *imaging.Processor
+	Analyzer           analyze.Analyzer
+	MediaIndex         *mediaindex.Index
+	MediaGC            *mediagc.Collector
+	Uploads            *resumable.Store
+}
+
+// Me returns the canonical "me" URL of the site.
+func (s *Site) Me() string {
+	return s.Micropub.MeUrl
+}
+
+// SiteRegistry holds every site hosted by this instance and resolves them by their "me" URL.
+type SiteRegistry struct {
+	sites []*Site
+}
+
+func NewSiteRegistry() *SiteRegistry {
+	return &SiteRegistry{}
+}
+
+// Add registers a site with the registry.
+func (sr *SiteRegistry) Add(site *Site) {
+	sr.sites = append(sr.sites, site)
+}
+
+// All returns every registered site, in registration order.
+func (sr *SiteRegistry) All() []*Site {
+	return sr.sites
+}
+
+// ForMe returns the site whose "me" URL matches the given value, or nil if there is none.
+func (sr *SiteRegistry) ForMe(me string) *Site {
+	me = normalizeMe(me)
+	for _, site := range sr.sites {
+		if normalizeMe(site.Me()) == me {
+			return site
+		}
+	}
+
+	return nil
+}
+
+func normalizeMe(me string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(me), "/"))
+}
+
+// AddSite stores the site a request is operating on in the context.
+func AddSite(ctx context.Context, site *Site) context.Context {
+	return context.WithValue(ctx, siteKey, site)
+}
+
+// GetSite retrieves the site a request is operating on from the context, if any.
+func GetSite(ctx context.Context) *Site {
+	site, ok := ctx.Value(siteKey).(*Site)
+	if !ok {
+		return nil
+	}
+
+	return site
+}
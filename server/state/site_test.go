@@ -0,0 +1,29 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+func TestForMe(t *testing.T) {
+	a := &Site{Micropub: &config.Micropub{MeUrl: "https://a.example/"}}
+	b := &Site{Micropub: &config.Micropub{MeUrl: "https://B.example"}}
+
+	sites := NewSiteRegistry()
+	sites.Add(a)
+	sites.Add(b)
+
+	tests := map[string]*Site{
+		"https://a.example":    a,
+		"https://A.example/":   a,
+		" https://b.example/ ": b,
+		"https://c.example/":   nil,
+		"":                     nil,
+	}
+	for me, want := range tests {
+		if got := sites.ForMe(me); got != want {
+			t.Errorf("ForMe(%q) = %v, want %v", me, got, want)
+		}
+	}
+}
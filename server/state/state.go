@@ -2,15 +2,11 @@ package state
 
 import (
 	"github.com/indieinfra/scribble/config"
-	"github.com/indieinfra/scribble/storage/content"
-	"github.com/indieinfra/scribble/storage/media"
-	"github.com/indieinfra/scribble/storage/util"
+	"github.com/indieinfra/scribble/server/ratelimit"
 )
 
 type ScribbleState struct {
-	Cfg                *config.Config
-	ContentPathPattern *util.PathPattern
-	MediaPathPattern   *util.PathPattern
-	ContentStore       content.Store
-	MediaStore         media.Store
+	Cfg         *config.Config
+	Sites       *SiteRegistry
+	RateLimiter *ratelimit.Limiter
 }
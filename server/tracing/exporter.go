@@ -0,0 +1,262 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+const (
+	defaultBatchSize     = 256
+	defaultFlushInterval = 5 * time.Second
+	// maxQueuedSpans bounds memory use when the collector is unreachable; excess spans are dropped.
+	maxQueuedSpans = 4096
+)
+
+// stdoutExporter writes each finished span as a JSON line, for local testing.
+type stdoutExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newStdoutExporter() *stdoutExporter {
+	return &stdoutExporter{out: os.Stdout}
+}
+
+func (e *stdoutExporter) export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_ = json.NewEncoder(e.out).Encode(span.otlp())
+}
+
+func (e *stdoutExporter) shutdown(context.Context) error {
+	return nil
+}
+
+// otlpExporter batches spans and posts them to an OTLP/HTTP collector using the JSON encoding. Scribble
+// emits only the handful of span fields below, which keeps the OpenTelemetry SDK and its protobuf
+// dependencies out of the binary; the payload is tested against the OTLP reference request.
+type otlpExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.Mutex
+	queue   []*Span
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+func newOtlpExporter(cfg *config.Tracing, serviceName string) *otlpExporter {
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+
+	e := &otlpExporter{
+		endpoint:    endpoint,
+		headers:     cfg.Headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flushCh:     make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) export(span *Span) {
+	e.mu.Lock()
+	if len(e.queue) >= maxQueuedSpans {
+		e.mu.Unlock()
+		return
+	}
+	e.queue = append(e.queue, span)
+	full := len(e.queue) >= defaultBatchSize
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.doneCh)
+
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.flush(context.Background())
+		case <-e.flushCh:
+			e.flush(context.Background())
+		case <-e.stopCh:
+			return
+		}
+	}
+}
+
+func (e *otlpExporter) flush(ctx context.Context) {
+	e.mu.Lock()
+	batch := e.queue
+	e.queue = nil
+	e.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := e.send(ctx, batch); err != nil {
+		slog.Warn("failed to export spans", "count", len(batch), "error", err)
+	}
+}
+
+func (e *otlpExporter) send(ctx context.Context, batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		spans[i] = span.otlp()
+	}
+
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{stringAttribute("service.name", e.serviceName)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/indieinfra/scribble"},
+				"spans": spans,
+			}},
+		}},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (e *otlpExporter) shutdown(ctx context.Context) error {
+	close(e.stopCh)
+
+	select {
+	case <-e.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	e.flush(ctx)
+	return nil
+}
+
+// otlpSpan is the OTLP/JSON representation of a span. Trace and span IDs are hex encoded, and
+// timestamps are nanoseconds since the epoch encoded as strings.
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func stringAttribute(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"stringValue": value}}
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := otlpSpan{
+		TraceId:           s.ctx.TraceID.String(),
+		SpanId:            s.ctx.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+
+	if s.parent != (SpanID{}) {
+		out.ParentSpanId = s.parent.String()
+	}
+
+	for k, v := range s.attributes {
+		var value map[string]any
+		switch x := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": x}
+		case bool:
+			value = map[string]any{"boolValue": x}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": x}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out.Attributes = append(out.Attributes, otlpAttribute{Key: k, Value: value})
+	}
+
+	if s.errMsg != "" {
+		out.Status = &otlpStatus{Code: 2, Message: s.errMsg}
+	}
+
+	return out
+}
@@ -0,0 +1,253 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+// collectorRequest mirrors ExportTraceServiceRequest in the OTLP/JSON encoding, as a collector
+// decodes it. It is checked against testdata/trace.json, the example request published with the
+// OTLP protocol definitions.
+type collectorRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []collectorAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name       string               `json:"name"`
+				Version    string               `json:"version"`
+				Attributes []collectorAttribute `json:"attributes"`
+			} `json:"scope"`
+			Spans []collectorSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type collectorSpan struct {
+	TraceId           string               `json:"traceId"`
+	SpanId            string               `json:"spanId"`
+	ParentSpanId      string               `json:"parentSpanId"`
+	Name              string               `json:"name"`
+	Kind              int                  `json:"kind"`
+	StartTimeUnixNano string               `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string               `json:"endTimeUnixNano"`
+	Attributes        []collectorAttribute `json:"attributes"`
+	Status            *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type collectorAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string  `json:"stringValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		IntValue    *string  `json:"intValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+// decodeCollectorRequest decodes body strictly and checks the constraints a collector enforces on
+// identifiers, timestamps and attribute values.
+func decodeCollectorRequest(t *testing.T, body []byte) collectorRequest {
+	t.Helper()
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	var req collectorRequest
+	if err := dec.Decode(&req); err != nil {
+		t.Fatalf("collector would reject payload: %v\n%s", err, body)
+	}
+
+	for _, rs := range req.ResourceSpans {
+		checkAttributes(t, rs.Resource.Attributes)
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				checkHex(t, "traceId", span.TraceId, 16)
+				checkHex(t, "spanId", span.SpanId, 8)
+				if span.ParentSpanId != "" {
+					checkHex(t, "parentSpanId", span.ParentSpanId, 8)
+				}
+				if span.Kind < 0 || span.Kind > 5 {
+					t.Errorf("span %q: kind %d out of range", span.Name, span.Kind)
+				}
+				start, err1 := strconv.ParseUint(span.StartTimeUnixNano, 10, 64)
+				end, err2 := strconv.ParseUint(span.EndTimeUnixNano, 10, 64)
+				if err1 != nil || err2 != nil || end < start {
+					t.Errorf("span %q: bad timestamps %q..%q", span.Name, span.StartTimeUnixNano, span.EndTimeUnixNano)
+				}
+				checkAttributes(t, span.Attributes)
+			}
+		}
+	}
+
+	return req
+}
+
+func checkHex(t *testing.T, field string, value string, size int) {
+	t.Helper()
+
+	if b, err := hex.DecodeString(value); err != nil || len(b) != size {
+		t.Errorf("%s %q is not %d hex-encoded bytes", field, value, size)
+	}
+}
+
+func checkAttributes(t *testing.T, attrs []collectorAttribute) {
+	t.Helper()
+
+	for _, a := range attrs {
+		set := 0
+		for _, present := range []bool{a.Value.StringValue != nil, a.Value.BoolValue != nil, a.Value.IntValue != nil, a.Value.DoubleValue != nil} {
+			if present {
+				set++
+			}
+		}
+		if set != 1 {
+			t.Errorf("attribute %q has %d values", a.Key, set)
+		}
+		if a.Value.IntValue != nil {
+			if _, err := strconv.ParseInt(*a.Value.IntValue, 10, 64); err != nil {
+				t.Errorf("attribute %q: intValue %q is not a decimal int64", a.Key, *a.Value.IntValue)
+			}
+		}
+	}
+}
+
+func TestCollectorSchemaAcceptsReferencePayload(t *testing.T) {
+	data, err := os.ReadFile("testdata/trace.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := decodeCollectorRequest(t, data)
+	if got := req.ResourceSpans[0].ScopeSpans[0].Spans[0].Name; got != "I'm a server span" {
+		t.Errorf("name = %q", got)
+	}
+}
+
+func TestOtlpExporterPayload(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Method != http.MethodPost {
+			t.Errorf("request to %s %s", r.Method, r.URL.Path)
+		}
+		header = r.Header.Clone()
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer collector.Close()
+
+	e := newOtlpExporter(&config.Tracing{Endpoint: collector.URL, Headers: map[string]string{"Authorization": "Bearer abc"}}, "scribble-test")
+
+	tracer := &Tracer{serviceName: "scribble-test", sampleRatio: 1, exporter: e}
+	start := time.Unix(1700000000, 0)
+	parent := &Span{
+		tracer: tracer, name: "POST /micropub", kind: KindServer, start: start, end: start.Add(time.Second),
+		ctx:        SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true},
+		attributes: map[string]any{"http.request.method": "POST", "http.response.status_code": 502, "size": int64(1 << 40), "ratio": 0.5, "cached": false},
+		errMsg:     "Bad Gateway",
+	}
+	child := &Span{
+		tracer: tracer, name: "PUT media.example.com", kind: KindClient, start: start, end: start.Add(time.Millisecond),
+		ctx:        SpanContext{TraceID: parent.ctx.TraceID, SpanID: newSpanID(), Sampled: true},
+		parent:     parent.ctx.SpanID,
+		attributes: map[string]any{"url.full": "https://media.example.com/a.jpg"},
+	}
+	e.export(child)
+	e.export(parent)
+
+	if err := e.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	default:
+		t.Fatal("shutdown did not flush queued spans")
+	}
+
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if auth := header.Get("Authorization"); auth != "Bearer abc" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	req := decodeCollectorRequest(t, body)
+	rs := req.ResourceSpans[0]
+	if a := rs.Resource.Attributes; len(a) != 1 || a[0].Key != "service.name" || *a[0].Value.StringValue != "scribble-test" {
+		t.Errorf("resource attributes = %+v", a)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(spans))
+	}
+
+	gotChild, gotParent := spans[0], spans[1]
+	if gotChild.ParentSpanId != gotParent.SpanId || gotChild.TraceId != gotParent.TraceId {
+		t.Errorf("child %s/%s is not under parent %s/%s", gotChild.TraceId, gotChild.ParentSpanId, gotParent.TraceId, gotParent.SpanId)
+	}
+	if gotParent.ParentSpanId != "" {
+		t.Errorf("root span has parent %q", gotParent.ParentSpanId)
+	}
+	if gotParent.Kind != 2 || gotChild.Kind != 3 {
+		t.Errorf("kinds = %d, %d", gotParent.Kind, gotChild.Kind)
+	}
+	if gotParent.StartTimeUnixNano != "1700000000000000000" || gotParent.EndTimeUnixNano != "1700000001000000000" {
+		t.Errorf("times = %s..%s", gotParent.StartTimeUnixNano, gotParent.EndTimeUnixNano)
+	}
+	if s := gotParent.Status; s == nil || s.Code != 2 || s.Message != "Bad Gateway" {
+		t.Errorf("parent status = %+v, want error", s)
+	}
+	if gotChild.Status != nil {
+		t.Errorf("child status = %+v, want unset", gotChild.Status)
+	}
+
+	attrs := make(map[string]collectorAttribute)
+	for _, a := range gotParent.Attributes {
+		attrs[a.Key] = a
+	}
+	if v := attrs["http.response.status_code"].Value.IntValue; v == nil || *v != "502" {
+		t.Errorf("status code attribute = %+v", attrs["http.response.status_code"].Value)
+	}
+	if v := attrs["size"].Value.IntValue; v == nil || *v != "1099511627776" {
+		t.Errorf("int64 attribute = %+v", attrs["size"].Value)
+	}
+	if v := attrs["ratio"].Value.DoubleValue; v == nil || *v != 0.5 {
+		t.Errorf("double attribute = %+v", attrs["ratio"].Value)
+	}
+	if v := attrs["cached"].Value.BoolValue; v == nil || *v {
+		t.Errorf("bool attribute = %+v", attrs["cached"].Value)
+	}
+}
+
+func TestOtlpExporterReportsRejectedBatches(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	e := &otlpExporter{endpoint: collector.URL + "/v1/traces", client: collector.Client()}
+	span := &Span{ctx: SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}, attributes: map[string]any{}}
+
+	if err := e.send(context.Background(), []*Span{span}); err == nil {
+		t.Fatal("expected an error for a rejected batch")
+	}
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/indieinfra/scribble/server/util"
+)
+
+// Middleware starts a server span for each request, continuing the caller's trace when a valid
+// traceparent header is present.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, ok := Extract(r.Header); ok {
+			ctx = ContextWithRemoteParent(ctx, parent)
+		}
+
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path, KindServer)
+		if span == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		// Tie handler log lines to the trace so both can be cross-referenced.
+		ctx = util.ContextWithLogAttrs(ctx, slog.String("trace_id", span.ctx.TraceID.String()))
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("user_agent.original", r.UserAgent())
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttribute("http.response.status_code", sw.status)
+		if sw.status >= http.StatusInternalServerError {
+			span.RecordError(errorStatus(sw.status))
+		}
+	})
+}
+
+// Transport wraps an outbound transport so each request gets a client span and carries the
+// traceparent header. A nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return roundTripper{base: base}
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), req.Method+" "+req.URL.Host, KindClient)
+	if span == nil {
+		// Unsampled traces are still propagated so downstream services honour the decision.
+		if _, ok := ctx.Value(remoteKey).(SpanContext); ok {
+			req = req.Clone(ctx)
+			Inject(ctx, req.Header)
+		}
+		return rt.base.RoundTrip(req)
+	}
+	defer span.End()
+
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.full", req.URL.Redacted())
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.RecordError(errorStatus(resp.StatusCode))
+	}
+
+	return resp, nil
+}
+
+type errorStatus int
+
+func (e errorStatus) Error() string {
+	return http.StatusText(int(e))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
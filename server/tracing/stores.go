@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+	"github.com/indieinfra/scribble/storage/media"
+)
+
+// TraceContentStore wraps a content store so every call is recorded as a span.
+func TraceContentStore(strategy string, store content.Store) content.Store {
+	return &contentStore{strategy: strategy, next: store}
+}
+
+// TraceMediaStore wraps a media store so every call is recorded as a span.
+func TraceMediaStore(strategy string, store media.Store) media.Store {
+	return &mediaStore{strategy: strategy, next: store}
+}
+
+// startStoreSpan starts a span for a store call; finish must be deferred with the call's error.
+func startStoreSpan(ctx context.Context, kind string, strategy string, method string) (context.Context, func(error)) {
+	ctx, span := Start(ctx, kind+"."+method, KindInternal)
+	span.SetAttribute("scribble.store.strategy", strategy)
+
+	return ctx, func(err error) {
+		span.RecordError(err)
+		span.End()
+	}
+}
+
+type contentStore struct {
+	strategy string
+	next     content.Store
+}
+
+func (s *contentStore) Create(ctx context.Context, doc util.Mf2Document) (url string, now bool, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "Create")
+	defer func() { finish(err) }()
+	return s.next.Create(ctx, doc)
+}
+
+func (s *contentStore) Update(ctx context.Context, url string, replacements map[string][]any, additions map[string][]any, deletions any) (newUrl string, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "Update")
+	defer func() { finish(err) }()
+	return s.next.Update(ctx, url, replacements, additions, deletions)
+}
+
+func (s *contentStore) Delete(ctx context.Context, url string) (newUrl string, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "Delete")
+	defer func() { finish(err) }()
+	return s.next.Delete(ctx, url)
+}
+
+func (s *contentStore) Undelete(ctx context.Context, url string) (newUrl string, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "Undelete")
+	defer func() { finish(err) }()
+	return s.next.Undelete(ctx, url)
+}
+
+func (s *contentStore) Get(ctx context.Context, url string) (doc *util.Mf2Document, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "Get")
+	defer func() { finish(err) }()
+	return s.next.Get(ctx, url)
+}
+
+func (s *contentStore) List(ctx context.Context, page int, limit int) (docs []util.Mf2Document, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "List")
+	defer func() { finish(err) }()
+	return s.next.List(ctx, page, limit)
+}
+
+func (s *contentStore) ListCategories(ctx context.Context, page int, limit int, filter string) (categories []string, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "ListCategories")
+	defer func() { finish(err) }()
+	return s.next.ListCategories(ctx, page, limit, filter)
+}
+
+func (s *contentStore) ExistsBySlug(ctx context.Context, slug string) (exists bool, err error) {
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "ExistsBySlug")
+	defer func() { finish(err) }()
+	return s.next.ExistsBySlug(ctx, slug)
+}
+
+// HealthCheck forwards to the wrapped store when it supports health checks.
+func (s *contentStore) HealthCheck(ctx context.Context) (err error) {
+	checker, ok := s.next.(content.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	ctx, finish := startStoreSpan(ctx, "content", s.strategy, "HealthCheck")
+	defer func() { finish(err) }()
+	return checker.HealthCheck(ctx)
+}
+
+type mediaStore struct {
+	strategy string
+	next     media.Store
+}
+
+func (s *mediaStore) Upload(ctx context.Context, r io.Reader, size int64, contentType string, key string) (url string, err error) {
+	ctx, finish := startStoreSpan(ctx, "media", s.strategy, "Upload")
+	defer func() { finish(err) }()
+
+	if span := SpanFromContext(ctx); span != nil {
+		if size >= 0 {
+			span.SetAttribute("scribble.media.size", size)
+		}
+		span.SetAttribute("scribble.media.key", key)
+	}
+
+	return s.next.Upload(ctx, r, size, contentType, key)
+}
+
+func (s *mediaStore) Delete(ctx context.Context, url string) (err error) {
+	ctx, finish := startStoreSpan(ctx, "media", s.strategy, "Delete")
+	defer func() { finish(err) }()
+	return s.next.Delete(ctx, url)
+}
+
+func (s *mediaStore) Stat(ctx context.Context, key string) (info *media.ObjectInfo, err error) {
+	ctx, finish := startStoreSpan(ctx, "media", s.strategy, "Stat")
+	defer func() { finish(err) }()
+	return s.next.Stat(ctx, key)
+}
+
+func (s *mediaStore) List(ctx context.Context, opts media.ListOptions) (objects []media.ObjectInfo, err error) {
+	ctx, finish := startStoreSpan(ctx, "media", s.strategy, "List")
+	defer func() { finish(err) }()
+	return s.next.List(ctx, opts)
+}
+
+// HealthCheck forwards to the wrapped store when it supports health checks.
+func (s *mediaStore) HealthCheck(ctx context.Context) (err error) {
+	checker, ok := s.next.(media.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	ctx, finish := startStoreSpan(ctx, "media", s.strategy, "HealthCheck")
+	defer func() { finish(err) }()
+	return checker.HealthCheck(ctx)
+}
@@ -0,0 +1,252 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+)
+
+// SpanKind mirrors the OpenTelemetry span kinds used by Scribble.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span within a trace, as carried by the W3C traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Span is a timed operation within a trace. A nil *Span is valid and ignores all calls, which is what
+// Start returns when tracing is disabled or the trace is not sampled.
+type Span struct {
+	tracer     *Tracer
+	ctx        SpanContext
+	parent     SpanID
+	name       string
+	kind       SpanKind
+	start      time.Time
+	end        time.Time
+	mu         sync.Mutex
+	attributes map[string]any
+	errMsg     string
+	ended      bool
+}
+
+// SetAttribute records a key/value pair on the span. Values should be strings, bools or numbers.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed. Nil errors are ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.errMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and hands it to the exporter. Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.exporter.export(s)
+}
+
+// Tracer creates spans and passes finished ones to an exporter.
+type Tracer struct {
+	serviceName string
+	sampleRatio float64
+	exporter    exporter
+}
+
+type exporter interface {
+	export(span *Span)
+	shutdown(ctx context.Context) error
+}
+
+var (
+	globalMu sync.RWMutex
+	global   *Tracer
+)
+
+// Setup installs the global tracer described by the config. When tracing is disabled it leaves
+// tracing off, making every Start call a no-op.
+func Setup(cfg *config.Tracing) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "scribble"
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	tracer := &Tracer{serviceName: serviceName, sampleRatio: ratio}
+
+	switch cfg.Exporter {
+	case "stdout":
+		tracer.exporter = newStdoutExporter()
+	case "otlp":
+		tracer.exporter = newOtlpExporter(cfg, serviceName)
+	default:
+		return fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+
+	globalMu.Lock()
+	global = tracer
+	globalMu.Unlock()
+
+	slog.Info("tracing enabled", "exporter", cfg.Exporter, "sample_ratio", ratio)
+	return nil
+}
+
+// Shutdown flushes buffered spans and stops the exporter.
+func Shutdown(ctx context.Context) error {
+	globalMu.Lock()
+	tracer := global
+	global = nil
+	globalMu.Unlock()
+
+	if tracer == nil {
+		return nil
+	}
+
+	return tracer.exporter.shutdown(ctx)
+}
+
+type spanKeyType struct{}
+
+var spanKey = spanKeyType{}
+
+type remoteKeyType struct{}
+
+var remoteKey = remoteKeyType{}
+
+// Start begins a span as a child of the span in ctx (or of a remote parent extracted from an incoming
+// request). The returned context carries the new span. When tracing is disabled, or the trace was not
+// sampled, the span is nil and ctx is returned unchanged.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	globalMu.RLock()
+	tracer := global
+	globalMu.RUnlock()
+
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.ctx
+	} else if remote, ok := ctx.Value(remoteKey).(SpanContext); ok {
+		parent = remote
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = tracer.sample(sc.TraceID)
+	}
+
+	if !sc.Sampled {
+		// Keep propagating the unsampled decision to downstream services.
+		return context.WithValue(ctx, remoteKey, SpanContext{TraceID: sc.TraceID, SpanID: sc.SpanID}), nil
+	}
+
+	span := &Span{
+		tracer:     tracer,
+		ctx:        sc,
+		parent:     parent.SpanID,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]any{},
+	}
+
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// SpanFromContext returns the active span, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent records a parent span received from another service, so the next span
+// started from ctx joins that trace.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// sample makes a deterministic decision from the trace ID, so every service sampling at the same ratio
+// agrees on which traces to keep.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+
+	bound := uint64(t.sampleRatio * float64(1<<63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
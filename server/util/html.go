@@ -1,6 +1,7 @@
 package util
 
 import (
+	"slices"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -97,3 +98,32 @@ func removeControlCharacters(text string) string {
 		return r
 	}, text)
 }
+
+// ExtractLinks returns the href of every <a> element in the HTML fragment, in document order and
+// without duplicates. Relative links are returned as written.
+func ExtractLinks(input string) []string {
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	var traverse func(*html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, attr := range n.Attr {
+				href := strings.TrimSpace(attr.Val)
+				if attr.Key == "href" && href != "" && !slices.Contains(links, href) {
+					links = append(links, href)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+
+	traverse(doc)
+	return links
+}
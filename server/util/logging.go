@@ -2,62 +2,110 @@ package util
 
 import (
 	"context"
-	"fmt"
-	"net/http"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/indieinfra/scribble/config"
 )
 
-type loggerKeyType struct{}
+type logAttrsKeyType struct{}
 
-var loggerKey = loggerKeyType{}
+var logAttrsKey = logAttrsKeyType{}
 
-// Logger is a minimal interface allowing substitution (e.g., zap, logrus).
-type Logger interface {
-	Printf(format string, v ...any)
-}
+// NewLogger builds a logger from the logging config. Records logged with a context carry any
+// attributes attached to that context with ContextWithLogAttrs (request ID, method, path, me).
+func NewLogger(cfg *config.Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: LogLevel(cfg)}
+
+	var handler slog.Handler
+	if strings.EqualFold(cfg.Logging.Format, "json") {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
 
-// RequestLogger holds request-scoped context to enrich logs.
-type RequestLogger struct {
-	logger Logger
-	method string
-	path   string
-	user   string
+	return slog.New(&contextHandler{next: handler})
 }
 
-// WithRequest creates a request-scoped logger wrapping the provided logger.
-func WithRequest(l Logger, r *http.Request, user string) *RequestLogger {
-	return &RequestLogger{
-		logger: l,
-		method: r.Method,
-		path:   r.URL.String(),
-		user:   user,
+// LogLevel resolves the configured log level. The legacy debug flag forces debug logging.
+func LogLevel(cfg *config.Config) slog.Level {
+	if cfg.Debug {
+		return slog.LevelDebug
+	}
+
+	switch strings.ToLower(cfg.Logging.Level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
 	}
 }
 
-// ContextWithLogger stores the request logger in context for downstream handlers.
-func ContextWithLogger(ctx context.Context, rl *RequestLogger) context.Context {
-	return context.WithValue(ctx, loggerKey, rl)
+// ContextWithLogAttrs returns a context whose log records carry the given attributes, in addition to
+// any attached earlier.
+func ContextWithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing := LogAttrsFromContext(ctx)
+	return context.WithValue(ctx, logAttrsKey, append(slices.Clip(existing), attrs...))
 }
 
-func (rl *RequestLogger) logf(level string, message string) {
-	prefix := fmt.Sprintf("%s [%s %s]", level, rl.method, rl.path)
-	if rl.user != "" {
-		prefix = fmt.Sprintf("%s (%s)", prefix, rl.user)
+// LogAttrsFromContext returns the log attributes attached to the context, if any.
+func LogAttrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
 	}
-	rl.logger.Printf("%s: %s", prefix, message)
+
+	attrs, _ := ctx.Value(logAttrsKey).([]slog.Attr)
+	return attrs
 }
 
-func (rl *RequestLogger) Infof(format string, v ...any)  { rl.logf("INFO", fmt.Sprintf(format, v...)) }
-func (rl *RequestLogger) Errorf(format string, v ...any) { rl.logf("ERROR", fmt.Sprintf(format, v...)) }
+// contextHandler adds the attributes stored in a record's context before passing it on.
+type contextHandler struct {
+	next slog.Handler
+}
 
-// FromContext retrieves a request logger from context when available.
-func FromContext(ctx context.Context) *RequestLogger {
-	if ctx == nil {
-		return nil
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := LogAttrsFromContext(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
 	}
 
-	if rl, ok := ctx.Value(loggerKey).(*RequestLogger); ok {
-		return rl
+	return h.next.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name)}
+}
+
+type requestIdKeyType struct{}
+
+var requestIdKey = requestIdKeyType{}
+
+// ContextWithRequestId stores the request's ID in the context and attaches it to log records.
+func ContextWithRequestId(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIdKey, id)
+	return ContextWithLogAttrs(ctx, slog.String("request_id", id))
+}
+
+// RequestIdFromContext returns the ID of the request the context belongs to, if any.
+func RequestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
 	}
 
-	return nil
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
 }
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"mime"
 	"net/http"
+	"path"
+	"regexp"
 	"slices"
+	"strings"
 
+	"github.com/indieinfra/scribble/config"
 	"github.com/indieinfra/scribble/server/resp"
 )
 
@@ -50,3 +54,70 @@ func requireValidContentType(w http.ResponseWriter, r *http.Request, valid []str
 		return r.Method, mediaType, false
 	}
 }
+
+// preferredExtensions picks the conventional extension for common media types, where the mime
+// package's table offers several (such as ".jpe" and ".jpeg" for image/jpeg).
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"image/heic":      ".heic",
+	"image/svg+xml":   ".svg",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/ogg":       ".ogg",
+	"audio/wav":       ".wav",
+	"audio/wave":      ".wav",
+	"application/pdf": ".pdf",
+}
+
+var safeExtension = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// FileExtension returns the extension, including the dot, to store a file of the given media type
+// under, falling back to the client's file name. It returns "" when neither gives a safe extension.
+func FileExtension(contentType string, filename string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+
+	if ext := strings.ToLower(path.Ext(filename)); safeExtension.MatchString(ext) {
+		return ext
+	}
+
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 && safeExtension.MatchString(exts[0]) {
+		return exts[0]
+	}
+
+	return ""
+}
+
+// FileSizeLimit returns the largest upload allowed for a file of the given media type: the most
+// specific matching entry of limits.MaxFileSizes ("image/jpeg" before "image/*"), or else
+// limits.MaxFileSize. Zero means no limit.
+func FileSizeLimit(limits *config.ServerLimits, mediaType string) int64 {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, key := range []string{mediaType, major + "/*", "*/*"} {
+		if size, ok := limits.MaxFileSizes[key]; ok {
+			return int64(size)
+		}
+	}
+
+	return int64(limits.MaxFileSize)
+}
+
+// LargestFileSize returns the largest upload allowed for any media type, which bounds how much of a
+// multipart request is read before the type of each file is known.
+func LargestFileSize(limits *config.ServerLimits) int64 {
+	largest := limits.MaxFileSize
+	for _, size := range limits.MaxFileSizes {
+		largest = max(largest, size)
+	}
+
+	return int64(largest)
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type MicroformatProperties map[string][]any
@@ -25,6 +26,18 @@ func (d *Mf2Document) AddProp(key string, value any) {
 	}
 }
 
+// IsDraft reports whether the document has post-status draft. Drafts are not public, so nothing
+// announcing them, such as Webmentions or WebSub pings, should be sent.
+func (d *Mf2Document) IsDraft() bool {
+	for _, v := range d.Properties["post-status"] {
+		if s, ok := v.(string); ok && s != "" {
+			return strings.EqualFold(s, "draft")
+		}
+	}
+
+	return false
+}
+
 func ValidateMf2(doc Mf2Document) error {
 	if len(doc.Type) == 0 {
 		return errors.New("mf2 type array must not be empty")
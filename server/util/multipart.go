@@ -1,99 +1,129 @@
 package util
 
 import (
-	"log"
+	"errors"
+	"io"
 	"mime/multipart"
 	"net/http"
 )
 
 type MultipartValues map[string]any
 
-type MultipartFile struct {
-	Field  string
-	File   multipart.File
-	Header *multipart.FileHeader
-}
+// ErrTooManyFiles is reported when a multipart request carries more files than allowed.
+var ErrTooManyFiles = errors.New("too many files in multipart request")
 
-type ParsedMultipart struct {
-	Values MultipartValues
-	Files  []MultipartFile
-}
+// toValues flattens form fields, keeping single values as strings and repeated ones as []any.
+func toValues(fields map[string][]string) MultipartValues {
+	values := make(MultipartValues)
 
-func (pm *ParsedMultipart) CloseFiles() {
-	for _, mf := range pm.Files {
-		if mf.File != nil {
-			mf.File.Close()
+	for key, arr := range fields {
+		switch len(arr) {
+		case 0:
+			continue
+		case 1:
+			values[key] = arr[0]
+		default:
+			asAny := make([]any, len(arr))
+			for i, v := range arr {
+				asAny[i] = v
+			}
+			values[key] = asAny
 		}
 	}
-}
 
-func (pm *ParsedMultipart) FileByKey(key string) *MultipartFile {
-	for _, mf := range pm.Files {
-		if mf.Field == key {
-			return &mf
-		}
-	}
+	return values
+}
 
-	return nil
+// ErrFieldsTooLarge is returned by MultipartStream when the form fields exceed their memory budget.
+var ErrFieldsTooLarge = errors.New("multipart form fields too large")
+
+// MultipartStream reads a multipart/form-data body part by part, so a file can be consumed as it
+// arrives instead of being spooled to memory or disk first. Form fields are collected as they are
+// passed, so only the fields preceding a file are known when it is returned.
+type MultipartStream struct {
+	reader     *multipart.Reader
+	fields     map[string][]string
+	fieldsLeft int64
+	pending    *multipart.Part
+	done       bool
 }
 
-func ParseMultipart(w http.ResponseWriter, r *http.Request, maxMemory, maxFileSize int64) (*ParsedMultipart, error) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxMemory)
-	if err := r.ParseMultipartForm(maxMemory); err != nil {
+// NewMultipartStream starts reading a multipart request. Form fields may take up to maxMemory bytes
+// in all, and the body as a whole may be no larger than that plus maxFileSize.
+func NewMultipartStream(w http.ResponseWriter, r *http.Request, maxMemory, maxFileSize int64) (*MultipartStream, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxMemory+maxFileSize)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
 		return nil, err
 	}
 
-	values := extractValues(r)
-	files := extractFiles(r, maxFileSize)
-
-	return &ParsedMultipart{
-		Values: values,
-		Files:  files,
+	return &MultipartStream{
+		reader:     reader,
+		fields:     make(map[string][]string),
+		fieldsLeft: maxMemory,
 	}, nil
 }
 
-func extractValues(r *http.Request) MultipartValues {
-	values := make(MultipartValues)
-
-	if r.MultipartForm != nil {
-		for key, arr := range r.MultipartForm.Value {
-			switch len(arr) {
-			case 0:
-				continue
-			case 1:
-				values[key] = arr[0]
-			default:
-				asAny := make([]any, len(arr))
-				for i, v := range arr {
-					asAny[i] = v
-				}
-				values[key] = asAny
-			}
-		}
+// NextFile returns the next file part, collecting any form fields before it, or io.EOF when the body
+// holds no more. A part is discarded if it has not been read by the next call.
+func (s *MultipartStream) NextFile() (*multipart.Part, error) {
+	if part := s.pending; part != nil {
+		s.pending = nil
+		return part, nil
+	}
+	if s.done {
+		return nil, io.EOF
 	}
 
-	return values
-}
+	for {
+		part, err := s.reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			s.done = true
+		}
+		if err != nil {
+			return nil, err
+		}
 
-func extractFiles(r *http.Request, maxFileSize int64) []MultipartFile {
-	var filesOut []MultipartFile
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
 
-	for key, fhs := range r.MultipartForm.File {
-		for _, fh := range fhs {
-			if maxFileSize > 0 && fh.Size > maxFileSize {
-				log.Println("skipped too large file:", fh.Filename, fh.Size)
-				continue
-			}
+		if part.FileName() != "" {
+			return part, nil
+		}
 
-			f, err := fh.Open()
-			if err != nil {
-				log.Println("skipped file, could not open:", fh.Filename, err)
-				continue
-			}
+		value, err := io.ReadAll(io.LimitReader(part, s.fieldsLeft+1))
+		if err != nil {
+			return nil, err
+		}
 
-			filesOut = append(filesOut, MultipartFile{Field: key, File: f, Header: fh})
+		s.fieldsLeft -= int64(len(value))
+		if s.fieldsLeft < 0 {
+			return nil, ErrFieldsTooLarge
 		}
+
+		s.fields[name] = append(s.fields[name], string(value))
+	}
+}
+
+// ReadFields reads ahead to the first file, or to the end of the body, so the form fields sent before
+// any file are known from Values. The file is still returned by the next call to NextFile.
+func (s *MultipartStream) ReadFields() error {
+	part, err := s.NextFile()
+	if errors.Is(err, io.EOF) {
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	s.pending = part
+	return nil
+}
 
-	return filesOut
+// Values returns the form fields read so far.
+func (s *MultipartStream) Values() MultipartValues {
+	return toValues(s.fields)
 }
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func multipartRequest(t *testing.T, files int, size int) *http.Request {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("h", "entry")
+	mw.WriteField("content", "A post with photos")
+	for i := range files {
+		part, err := mw.CreateFormFile("photo", fmt.Sprintf("photo-%d.jpg", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(bytes.Repeat([]byte{'x'}, size))
+	}
+	mw.WriteField("mp-photo-alt", "A bee")
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/micropub", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestMultipartStreamReadFields(t *testing.T) {
+	r := multipartRequest(t, 2, 1<<10)
+	stream, err := NewMultipartStream(httptest.NewRecorder(), r, 4<<10, 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stream.ReadFields(); err != nil {
+		t.Fatal(err)
+	}
+	if values := stream.Values(); values["content"] != "A post with photos" || values["mp-photo-alt"] != nil {
+		t.Errorf("fields before the files = %v", values)
+	}
+
+	for i := range 2 {
+		part, err := stream.NextFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("photo-%d.jpg", i); part.FileName() != want {
+			t.Errorf("file %d = %q, want %q", i, part.FileName(), want)
+		}
+	}
+
+	for range 2 {
+		if _, err := stream.NextFile(); !errors.Is(err, io.EOF) {
+			t.Fatalf("err = %v, want EOF", err)
+		}
+	}
+	if stream.Values()["mp-photo-alt"] != "A bee" {
+		t.Errorf("fields after the files = %v", stream.Values())
+	}
+}
+
+func TestMultipartStreamBoundsBody(t *testing.T) {
+	const (
+		maxMemory   = 4 << 10
+		maxFileSize = 64 << 10
+	)
+
+	// Several files may not add up to more than one of the largest size.
+	r := multipartRequest(t, 2, maxFileSize)
+	stream, err := NewMultipartStream(httptest.NewRecorder(), r, maxMemory, maxFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		part, err := stream.NextFile()
+		if err == nil {
+			_, err = io.Copy(io.Discard, part)
+		}
+		if errors.As(err, new(*http.MaxBytesError)) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("err = %v, want a MaxBytesError", err)
+		}
+	}
+}
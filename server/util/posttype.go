@@ -0,0 +1,48 @@
+package util
+
+import (
+	"slices"
+	"strings"
+)
+
+// DiscoverPostType determines the kind of post a document represents, following the order of
+// checks in the Post Type Discovery algorithm (https://www.w3.org/TR/post-type-discovery/). Posts
+// with audio, which the algorithm does not name, are "audio".
+func DiscoverPostType(doc Mf2Document) string {
+	if slices.Contains(doc.Type, "h-event") {
+		return "event"
+	}
+
+	checks := []struct {
+		property string
+		postType string
+	}{
+		{"rsvp", "rsvp"},
+		{"in-reply-to", "reply"},
+		{"repost-of", "repost"},
+		{"like-of", "like"},
+		{"bookmark-of", "bookmark"},
+		{"checkin", "checkin"},
+		{"video", "video"},
+		{"audio", "audio"},
+		{"photo", "photo"},
+	}
+
+	for _, check := range checks {
+		if len(doc.Properties[check.property]) > 0 {
+			return check.postType
+		}
+	}
+
+	name := strings.Join(strings.Fields(extractTextFromProperty(doc.Properties["name"])), " ")
+	if name == "" {
+		return "note"
+	}
+
+	content := strings.Join(strings.Fields(extractTextFromProperty(doc.Properties["content"])), " ")
+	if content != "" && strings.HasPrefix(content, name) {
+		return "note"
+	}
+
+	return "article"
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SniffLen is how much of a file is needed to determine its type, as with http.DetectContentType.
+const SniffLen = 512
+
+// SniffContentType determines the media type of a file from its first bytes with DetectContentType,
+// leaving the file positioned at the start.
+func SniffContentType(file io.ReadSeeker) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return DetectContentType(head[:n]), nil
+}
+
+// DetectContentType determines the media type of a file from its first SniffLen bytes, ignoring
+// whatever the client claimed. It recognises what http.DetectContentType does, plus the ISO base
+// media formats (HEIC, AVIF, QuickTime, M4A and MP4 variants) and Ogg streams it reports only
+// generically. Unrecognised binary data is "application/octet-stream".
+func DetectContentType(head []byte) string {
+	if t := sniffIsoMedia(head); t != "" {
+		return t
+	}
+
+	if t := sniffOgg(head); t != "" {
+		return t
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return mediaType
+}
+
+// isoBrands maps ISO base media file brands to media types. HEIF brands shared by AVIF ("mif1",
+// "msf1") are resolved by looking for an AVIF compatible brand first.
+var isoBrands = map[string]string{
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"mif1": "image/heic",
+	"msf1": "image/heic",
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+	"M4B ": "audio/mp4",
+	"M4V ": "video/mp4",
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"iso4": "video/mp4",
+	"iso5": "video/mp4",
+	"iso6": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+	"3gp6": "video/3gpp",
+}
+
+// sniffIsoMedia identifies a file starting with an ISO base media "ftyp" box by its brands.
+func sniffIsoMedia(head []byte) string {
+	if len(head) < 16 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+
+	size := int(binary.BigEndian.Uint32(head[:4]))
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+
+	major := string(head[8:12])
+	for i := 16; i+4 <= size; i += 4 {
+		if b := string(head[i : i+4]); b == "avif" || b == "avis" {
+			return "image/avif"
+		}
+	}
+
+	return isoBrands[major]
+}
+
+// sniffOgg tells Ogg audio from Ogg video by the codec named in the first page.
+func sniffOgg(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("OggS")) {
+		return ""
+	}
+
+	switch {
+	case bytes.Contains(head, []byte("theora")):
+		return "video/ogg"
+	case bytes.Contains(head, []byte("OpusHead")), bytes.Contains(head, []byte("vorbis")), bytes.Contains(head, []byte("FLAC")):
+		return "audio/ogg"
+	default:
+		return "application/ogg"
+	}
+}
+
+// MatchMediaType reports whether mediaType matches any of patterns, which are media types or
+// wildcards such as "image/*" and "*/*".
+func MatchMediaType(patterns []string, mediaType string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "*/*" || p == mediaType || p == major+"/*" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,42 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the release version of Scribble. It is set at build time with
+// -ldflags "-X github.com/indieinfra/scribble/server/version.Version=v1.2.3".
+var Version = "dev"
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// Get returns build information, including VCS details embedded by the Go toolchain when available.
+func Get() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version()}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,346 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/tracing"
+)
+
+const (
+	SignatureHeader = "X-Scribble-Signature"
+	EventHeader     = "X-Scribble-Event"
+	DeliveryHeader  = "X-Scribble-Delivery"
+
+	defaultMaxAttempts = 8
+	baseBackoff        = 10 * time.Second
+	maxBackoff         = time.Hour
+	pollInterval       = 5 * time.Second
+)
+
+// delivery is a single pending POST of an event to a webhook. Deliveries are persisted as JSON files so
+// they survive restarts; secrets are never written to disk and are looked up from config when sending.
+type delivery struct {
+	Id          string          `json:"id"`
+	Url         string          `json:"url"`
+	Event       events.Type     `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Dispatcher delivers a site's events to its configured webhooks from a persisted queue.
+type Dispatcher struct {
+	me     string
+	hooks  map[string]config.Webhook
+	dir    string
+	client *http.Client
+
+	mu      sync.Mutex
+	pending map[string]*delivery
+
+	wake   chan struct{}
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewDispatcher creates a dispatcher for the site's webhooks, queueing deliveries under dataDir. Any
+// deliveries left over from a previous run are loaded and retried.
+func NewDispatcher(me string, hooks []config.Webhook, dataDir string) (*Dispatcher, error) {
+	sum := sha256.Sum256([]byte(me))
+	dir := filepath.Join(dataDir, "webhooks", hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook queue directory: %w", err)
+	}
+
+	d := &Dispatcher{
+		me:      me,
+		hooks:   make(map[string]config.Webhook, len(hooks)),
+		dir:     dir,
+		client:  &http.Client{Timeout: 15 * time.Second, Transport: tracing.Transport(nil)},
+		pending: make(map[string]*delivery),
+		wake:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	for _, hook := range hooks {
+		d.hooks[hook.Url] = hook
+	}
+
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	go d.run()
+	return d, nil
+}
+
+// Handle queues a delivery of the event for every webhook subscribed to its type.
+func (d *Dispatcher) Handle(ctx context.Context, ev events.Event) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode webhook payload", "event", ev.Type, "error", err)
+		return
+	}
+
+	for _, hook := range d.hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, string(ev.Type)) {
+			continue
+		}
+
+		dl := &delivery{
+			Id:          uuid.NewString(),
+			Url:         hook.Url,
+			Event:       ev.Type,
+			Payload:     payload,
+			NextAttempt: time.Now(),
+		}
+
+		if err := d.persist(dl); err != nil {
+			slog.ErrorContext(ctx, "failed to queue webhook delivery", "webhook", hook.Url, "error", err)
+			continue
+		}
+
+		d.mu.Lock()
+		d.pending[dl.Id] = dl
+		d.mu.Unlock()
+
+		slog.DebugContext(ctx, "queued webhook delivery", "webhook", hook.Url, "delivery", dl.Id, "event", ev.Type)
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the delivery worker. Undelivered events remain queued on disk for the next start.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	close(d.stopCh)
+
+	select {
+	case <-d.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.doneCh)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue()
+
+		select {
+		case <-d.stopCh:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue() {
+	now := time.Now()
+
+	d.mu.Lock()
+	var due []*delivery
+	for _, dl := range d.pending {
+		if !dl.NextAttempt.After(now) {
+			due = append(due, dl)
+		}
+	}
+	d.mu.Unlock()
+
+	slices.SortFunc(due, func(a, b *delivery) int { return a.NextAttempt.Compare(b.NextAttempt) })
+
+	for _, dl := range due {
+		select {
+		case <-d.stopCh:
+			return
+		default:
+		}
+
+		d.attempt(dl)
+	}
+}
+
+func (d *Dispatcher) attempt(dl *delivery) {
+	logger := slog.With("me", d.me, "webhook", dl.Url, "delivery", dl.Id, "event", dl.Event)
+
+	hook, ok := d.hooks[dl.Url]
+	if !ok {
+		logger.Warn("dropping delivery for webhook no longer configured")
+		d.remove(dl)
+		return
+	}
+
+	dl.Attempts++
+	err := d.send(hook, dl)
+	if err == nil {
+		logger.Info("delivered webhook", "attempts", dl.Attempts)
+		d.remove(dl)
+		return
+	}
+
+	maxAttempts := hook.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	dl.LastError = err.Error()
+	if dl.Attempts >= maxAttempts {
+		logger.Error("giving up on webhook delivery", "attempts", dl.Attempts, "error", err)
+		d.fail(dl)
+		return
+	}
+
+	dl.NextAttempt = time.Now().Add(backoff(dl.Attempts))
+	logger.Warn("webhook delivery failed, will retry", "attempts", dl.Attempts, "next_attempt", dl.NextAttempt, "error", err)
+	if err := d.persist(dl); err != nil {
+		logger.Error("failed to update queued webhook delivery", "error", err)
+	}
+}
+
+func (d *Dispatcher) send(hook config.Webhook, dl *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(dl.Event))
+	req.Header.Set(DeliveryHeader, dl.Id)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the signature header value for a payload: "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the body keyed with the webhook secret.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the delay with each attempt, capped at maxBackoff, with up to 20% jitter so retries
+// from many deliveries spread out.
+func backoff(attempts int) time.Duration {
+	delay := maxBackoff
+	if attempts < 16 {
+		delay = min(baseBackoff<<(attempts-1), maxBackoff)
+	}
+
+	return delay + time.Duration(rand.Int64N(int64(delay)/5+1))
+}
+
+func (d *Dispatcher) path(dl *delivery) string {
+	return filepath.Join(d.dir, dl.Id+".json")
+}
+
+func (d *Dispatcher) persist(dl *delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated delivery behind.
+	tmp := d.path(dl) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.path(dl))
+}
+
+func (d *Dispatcher) remove(dl *delivery) {
+	d.mu.Lock()
+	delete(d.pending, dl.Id)
+	d.mu.Unlock()
+
+	if err := os.Remove(d.path(dl)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("failed to remove webhook delivery", "delivery", dl.Id, "error", err)
+	}
+}
+
+// fail keeps an exhausted delivery on disk, renamed so it is no longer retried, for inspection.
+func (d *Dispatcher) fail(dl *delivery) {
+	d.mu.Lock()
+	delete(d.pending, dl.Id)
+	d.mu.Unlock()
+
+	if err := d.persist(dl); err == nil {
+		err = os.Rename(d.path(dl), strings.TrimSuffix(d.path(dl), ".json")+".failed")
+		if err != nil {
+			slog.Error("failed to mark webhook delivery as failed", "delivery", dl.Id, "error", err)
+		}
+	}
+}
+
+func (d *Dispatcher) load() error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read webhook queue: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(d.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read queued webhook delivery: %w", err)
+		}
+
+		var dl delivery
+		if err := json.Unmarshal(data, &dl); err != nil {
+			slog.Warn("skipping unreadable webhook delivery", "file", entry.Name(), "error", err)
+			continue
+		}
+
+		d.pending[dl.Id] = &dl
+	}
+
+	if len(d.pending) > 0 {
+		slog.Info("resuming queued webhook deliveries", "me", d.me, "count", len(d.pending))
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package webmention
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/indieinfra/scribble/server/fetch"
+)
+
+// maxDiscoveryBody bounds how much of a target page is read while looking for its endpoint.
+const maxDiscoveryBody = 1 << 20
+
+// ErrNoEndpoint is returned when a target does not advertise a Webmention endpoint.
+var ErrNoEndpoint = errors.New("target does not advertise a webmention endpoint")
+
+var linkHeaderPattern = regexp.MustCompile(`<([^>]*)>\s*((?:;\s*[^;,]*)*)`)
+
+// DiscoverEndpoint finds the Webmention endpoint advertised by target, checking HTTP Link headers
+// before the first <link> or <a> element with rel="webmention", as the specification requires.
+// Relative endpoints are resolved against the final URL after redirects.
+func DiscoverEndpoint(ctx context.Context, client *http.Client, target string) (string, error) {
+	resp, err := fetch.Get(ctx, client, target, "text/html, application/xhtml+xml;q=0.9, */*;q=0.1")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", &statusError{code: resp.StatusCode}
+	}
+
+	base := resp.Request.URL
+
+	for _, header := range resp.Header.Values("Link") {
+		if endpoint, ok := endpointFromLinkHeader(header); ok {
+			return resolveEndpoint(base, endpoint)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return "", ErrNoEndpoint
+	}
+
+	body, err := fetch.ReadLimited(resp.Body, maxDiscoveryBody)
+	if err != nil && !errors.Is(err, fetch.ErrTooLarge) {
+		return "", err
+	}
+
+	if endpoint, ok := endpointFromHtml(body); ok {
+		return resolveEndpoint(base, endpoint)
+	}
+
+	return "", ErrNoEndpoint
+}
+
+func endpointFromLinkHeader(header string) (string, bool) {
+	for _, match := range linkHeaderPattern.FindAllStringSubmatch(header, -1) {
+		for _, param := range strings.Split(match[2], ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+				continue
+			}
+
+			if hasRel(strings.Trim(strings.TrimSpace(value), `"`), "webmention") {
+				return match[1], true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func endpointFromHtml(body []byte) (string, bool) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+
+	var found *string
+	var traverse func(*html.Node)
+	traverse = func(n *html.Node) {
+		if found != nil {
+			return
+		}
+
+		if n.Type == html.ElementNode && (n.Data == "link" || n.Data == "a") {
+			var rel, href string
+			hasHref := false
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					href, hasHref = attr.Val, true
+				}
+			}
+
+			if hasHref && hasRel(rel, "webmention") {
+				found = &href
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+
+	traverse(doc)
+	if found == nil {
+		return "", false
+	}
+
+	return *found, true
+}
+
+func hasRel(rel string, want string) bool {
+	return slices.ContainsFunc(strings.Fields(rel), func(r string) bool {
+		return strings.EqualFold(r, want)
+	})
+}
+
+// resolveEndpoint resolves a possibly relative endpoint. An empty href refers to the page itself.
+func resolveEndpoint(base *url.URL, endpoint string) (string, error) {
+	ref, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid endpoint %q", ErrNoEndpoint, endpoint)
+	}
+
+	resolved := base.ResolveReference(ref)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return "", fmt.Errorf("%w: unsupported endpoint %q", ErrNoEndpoint, resolved)
+	}
+
+	return resolved.String(), nil
+}
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("responded with status %d", e.code)
+}
+
+// retryable reports whether a failure may succeed if tried again later.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests || se.code == http.StatusRequestTimeout
+	}
+
+	return !errors.Is(err, ErrNoEndpoint) && !errors.Is(err, fetch.ErrPrivateAddress)
+}
@@ -0,0 +1,382 @@
+package webmention
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/fetch"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+)
+
+// StatusProperty is the document property recording the outcome of each Webmention sent for a post.
+const StatusProperty = "webmention-status"
+
+const (
+	StatusSent       = "sent"
+	StatusNoEndpoint = "no-endpoint"
+	StatusRetrying   = "retrying"
+	StatusFailed     = "failed"
+
+	defaultMaxAttempts = 3
+	baseBackoff        = 30 * time.Second
+	queueSize          = 256
+)
+
+// linkProperties hold the URLs a post responds to, each of which is sent a Webmention.
+var linkProperties = []string{"in-reply-to", "like-of", "repost-of", "bookmark-of"}
+
+// job asks the worker to send Webmentions for a post. A non-empty target retries just that target.
+type job struct {
+	ctx     context.Context
+	event   events.Event
+	target  string
+	attempt int
+}
+
+// Sender sends Webmentions for a site's published posts after they are created, updated or deleted.
+// Work happens on a single background worker, so status updates for the same post never race each
+// other.
+type Sender struct {
+	me          string
+	store       content.Store
+	client      *http.Client
+	maxAttempts int
+
+	jobs   chan job
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewSender(me string, store content.Store, cfg *config.Webmention) *Sender {
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	s := &Sender{
+		me:          me,
+		store:       store,
+		client:      fetch.NewClient(fetch.Options{Timeout: cfg.Timeout, AllowPrivate: cfg.AllowPrivate}),
+		maxAttempts: maxAttempts,
+		jobs:        make(chan job, queueSize),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+// Handle queues Webmentions for post lifecycle events. Media uploads are ignored.
+func (s *Sender) Handle(ctx context.Context, ev events.Event) {
+	switch ev.Type {
+	case events.PostCreated, events.PostUpdated, events.PostDeleted, events.PostUndeleted:
+		s.enqueue(job{ctx: ctx, event: ev})
+	}
+}
+
+func (s *Sender) enqueue(j job) {
+	select {
+	case s.jobs <- j:
+	case <-s.stopCh:
+	default:
+		slog.WarnContext(j.ctx, "webmention queue full, dropping job", "url", j.event.Url, "target", j.target)
+	}
+}
+
+// Close stops the worker. Queued and scheduled retries are abandoned.
+func (s *Sender) Close(ctx context.Context) error {
+	close(s.stopCh)
+
+	select {
+	case <-s.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sender) run() {
+	defer close(s.doneCh)
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case j := <-s.jobs:
+			s.process(j)
+		}
+	}
+}
+
+func (s *Sender) process(j job) {
+	ctx := j.ctx
+	source := j.event.Url
+
+	doc := j.event.Document
+	if doc == nil || j.target != "" {
+		// Deletions carry no document, and retries need the latest recorded statuses.
+		var err error
+		doc, err = s.store.Get(ctx, source)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not load post to send webmentions", "url", source, "error", err)
+			return
+		}
+	}
+
+	// Drafts are not public, so their links are left alone until an update publishes the post, at
+	// which point every target is sent a Webmention as if the post were new.
+	if doc.IsDraft() {
+		slog.DebugContext(ctx, "not sending webmentions for draft", "url", source)
+		return
+	}
+
+	statuses := readStatuses(doc)
+	deleted := isDeleted(doc)
+
+	// record decides whether a target's outcome is kept on the post. Retries only refresh targets
+	// already recorded; links removed by an update are notified once and then forgotten, while a
+	// deleted post keeps its record so the targets are known if it is undeleted.
+	var targets []string
+	var record func(target string) bool
+	if j.target != "" {
+		targets = []string{j.target}
+		record = func(target string) bool {
+			_, tracked := statuses[target]
+			return tracked
+		}
+	} else {
+		var current []string
+		if !deleted {
+			current = Targets(doc, source)
+		}
+
+		targets = current
+		for target := range statuses {
+			if !slices.Contains(current, target) {
+				targets = append(targets, target)
+				if !deleted {
+					delete(statuses, target)
+				}
+			}
+		}
+
+		record = func(target string) bool {
+			return deleted || slices.Contains(current, target)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+
+	for _, target := range targets {
+		status := s.send(ctx, source, target, j.attempt+1)
+		if record(target) {
+			statuses[target] = status
+		}
+
+		if status["status"] == StatusRetrying {
+			s.scheduleRetry(j, target, j.attempt+1)
+		}
+	}
+
+	s.saveStatuses(ctx, source, statuses)
+}
+
+// send discovers the target's endpoint and sends it a Webmention, returning the status to record.
+func (s *Sender) send(ctx context.Context, source string, target string, attempt int) map[string]any {
+	status := map[string]any{
+		"target":   target,
+		"attempts": attempt,
+		"updated":  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	endpoint, err := DiscoverEndpoint(ctx, s.client, target)
+	if err == nil {
+		status["endpoint"] = endpoint
+		var code int
+		code, err = s.post(ctx, endpoint, source, target)
+		if code != 0 {
+			status["code"] = code
+		}
+	}
+
+	switch {
+	case err == nil:
+		status["status"] = StatusSent
+		slog.InfoContext(ctx, "sent webmention", "source", source, "target", target, "endpoint", endpoint)
+	case errors.Is(err, ErrNoEndpoint):
+		status["status"] = StatusNoEndpoint
+		slog.DebugContext(ctx, "no webmention endpoint", "source", source, "target", target)
+	case retryable(err) && attempt < s.maxAttempts:
+		status["status"] = StatusRetrying
+		status["error"] = err.Error()
+		slog.WarnContext(ctx, "webmention failed, will retry", "source", source, "target", target, "attempt", attempt, "error", err)
+	default:
+		status["status"] = StatusFailed
+		status["error"] = err.Error()
+		slog.WarnContext(ctx, "webmention failed", "source", source, "target", target, "attempt", attempt, "error", err)
+	}
+
+	return status
+}
+
+func (s *Sender) post(ctx context.Context, endpoint string, source string, target string) (int, error) {
+	form := url.Values{"source": {source}, "target": {target}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", fetch.UserAgent())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, &statusError{code: resp.StatusCode}
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (s *Sender) scheduleRetry(j job, target string, attempt int) {
+	delay := baseBackoff << (attempt - 1)
+	time.AfterFunc(delay, func() {
+		s.enqueue(job{ctx: j.ctx, event: j.event, target: target, attempt: attempt})
+	})
+}
+
+// saveStatuses records the statuses on the post. The store is written directly rather than through
+// the Micropub handlers, so this does not publish another event.
+func (s *Sender) saveStatuses(ctx context.Context, source string, statuses map[string]map[string]any) {
+	var err error
+	if len(statuses) == 0 {
+		_, err = s.store.Update(ctx, source, nil, nil, []string{StatusProperty})
+	} else {
+		values := make([]any, 0, len(statuses))
+		for _, target := range slices.Sorted(maps.Keys(statuses)) {
+			values = append(values, statuses[target])
+		}
+		_, err = s.store.Update(ctx, source, map[string][]any{StatusProperty: values}, nil, nil)
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "could not record webmention status", "url", source, "error", err)
+	}
+}
+
+// Targets returns the URLs a post should send Webmentions to: the values of its response properties
+// and the links in its HTML content, resolved against the post's own URL. Links to the post itself
+// and non-HTTP links are skipped.
+func Targets(doc *util.Mf2Document, source string) []string {
+	base, err := url.Parse(source)
+	if err != nil {
+		return nil
+	}
+
+	var targets []string
+	add := func(raw string) {
+		ref, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return
+		}
+
+		u := base.ResolveReference(ref)
+		u.Fragment = ""
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return
+		}
+
+		target := u.String()
+		if target != source && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+
+	for _, prop := range linkProperties {
+		for _, v := range doc.Properties[prop] {
+			if u, ok := valueUrl(v); ok {
+				add(u)
+			}
+		}
+	}
+
+	for _, v := range doc.Properties["content"] {
+		if m, ok := v.(map[string]any); ok {
+			if h, ok := m["html"].(string); ok {
+				for _, link := range util.ExtractLinks(h) {
+					add(link)
+				}
+			}
+		}
+	}
+
+	return targets
+}
+
+// valueUrl extracts a URL from a property value: a plain string, an object with a value or url, or
+// an embedded h-cite with a url property.
+func valueUrl(v any) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case map[string]any:
+		if props, ok := x["properties"].(map[string]any); ok {
+			if urls, ok := props["url"].([]any); ok && len(urls) > 0 {
+				u, ok := urls[0].(string)
+				return u, ok
+			}
+		}
+		for _, key := range []string{"url", "value"} {
+			if u, ok := x[key].(string); ok {
+				return u, true
+			}
+		}
+	case util.Mf2Document:
+		if urls := x.Properties["url"]; len(urls) > 0 {
+			u, ok := urls[0].(string)
+			return u, ok
+		}
+	}
+
+	return "", false
+}
+
+func readStatuses(doc *util.Mf2Document) map[string]map[string]any {
+	statuses := make(map[string]map[string]any)
+	for _, v := range doc.Properties[StatusProperty] {
+		if m, ok := v.(map[string]any); ok {
+			if target, ok := m["target"].(string); ok {
+				statuses[target] = m
+			}
+		}
+	}
+
+	return statuses
+}
+
+func isDeleted(doc *util.Mf2Document) bool {
+	for _, v := range doc.Properties["deleted"] {
+		if b, ok := v.(bool); ok && b {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package webmention
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/util"
+	"github.com/indieinfra/scribble/storage/content"
+)
+
+// recordingStore records the statuses the sender saves.
+type recordingStore struct {
+	content.Store
+	saved chan map[string][]any
+}
+
+func (s *recordingStore) Update(_ context.Context, _ string, replacements map[string][]any, _ map[string][]any, _ any) (string, error) {
+	s.saved <- replacements
+	return "", nil
+}
+
+func TestSenderSkipsDraftsUntilPublished(t *testing.T) {
+	hits := make(chan string, 8)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.Method
+	}))
+	defer target.Close()
+
+	store := &recordingStore{saved: make(chan map[string][]any, 1)}
+	s := NewSender("https://example.com/", store, &config.Webmention{AllowPrivate: true})
+	defer s.Close(context.Background())
+
+	post := func(status string) *util.Mf2Document {
+		return &util.Mf2Document{Type: []string{"h-entry"}, Properties: util.MicroformatProperties{
+			"in-reply-to": {target.URL + "/post"},
+			"post-status": {status},
+		}}
+	}
+
+	s.Handle(context.Background(), events.Event{Type: events.PostCreated, Url: "https://example.com/a", Document: post("draft")})
+
+	select {
+	case <-hits:
+		t.Fatal("draft sent a webmention")
+	case <-store.saved:
+		t.Fatal("draft recorded webmention statuses")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.Handle(context.Background(), events.Event{Type: events.PostUpdated, Url: "https://example.com/a", Document: post("published")})
+
+	select {
+	case <-hits:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing the draft sent no webmention")
+	}
+
+	select {
+	case saved := <-store.saved:
+		if len(saved[StatusProperty]) != 1 {
+			t.Errorf("statuses = %v", saved[StatusProperty])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webmention status recorded")
+	}
+}
@@ -0,0 +1,167 @@
+package websub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/fetch"
+	"github.com/indieinfra/scribble/server/tracing"
+)
+
+const (
+	defaultMaxAttempts = 5
+	baseBackoff        = 5 * time.Second
+)
+
+// Publisher notifies a WebSub hub that a site's feeds changed. Pings are coalesced: events arriving
+// while a round of pings is in flight cause exactly one more round once it completes.
+type Publisher struct {
+	me          string
+	hub         string
+	topics      []string
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+
+	wake   chan context.Context
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewPublisher(me string, cfg *config.WebSub) *Publisher {
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	topics := cfg.Topics
+	if len(topics) == 0 {
+		topics = []string{me}
+	}
+
+	p := &Publisher{
+		me:          me,
+		hub:         cfg.Hub,
+		topics:      topics,
+		client:      &http.Client{Timeout: timeout, Transport: tracing.Transport(nil)},
+		maxAttempts: maxAttempts,
+		backoff:     baseBackoff,
+		wake:        make(chan context.Context, 1),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+
+	go p.run()
+	return p
+}
+
+// Handle schedules a ping for post lifecycle events. Media uploads and deletions do not change feeds,
+// and neither do drafts, which are left out until an update publishes them.
+func (p *Publisher) Handle(ctx context.Context, ev events.Event) {
+	if ev.Type == events.MediaUploaded || ev.Type == events.MediaDeleted {
+		return
+	}
+	if ev.Document != nil && ev.Document.IsDraft() {
+		return
+	}
+
+	select {
+	case p.wake <- ctx:
+	default:
+		// A round is already queued; it will cover this event too.
+	}
+}
+
+// Close stops the publisher, abandoning any ping still waiting to be retried.
+func (p *Publisher) Close(ctx context.Context) error {
+	close(p.stopCh)
+
+	select {
+	case <-p.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.doneCh)
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case ctx := <-p.wake:
+			for _, topic := range p.topics {
+				p.publish(ctx, topic)
+			}
+		}
+	}
+}
+
+// publish pings the hub for one topic, retrying failures with exponential backoff.
+func (p *Publisher) publish(ctx context.Context, topic string) {
+	for attempt := 1; ; attempt++ {
+		err := p.ping(ctx, topic)
+		if err == nil {
+			slog.InfoContext(ctx, "notified websub hub", "hub", p.hub, "topic", topic, "attempts", attempt)
+			return
+		}
+
+		if attempt >= p.maxAttempts {
+			slog.ErrorContext(ctx, "giving up on websub hub notification", "hub", p.hub, "topic", topic, "attempts", attempt, "error", err)
+			return
+		}
+
+		delay := p.backoff << (attempt - 1)
+		slog.WarnContext(ctx, "websub hub notification failed, will retry", "hub", p.hub, "topic", topic, "attempt", attempt, "retry_in", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// ping sends a hub.mode=publish request. The topic is sent as both hub.url and hub.topic, since hubs
+// differ in which they read.
+func (p *Publisher) ping(ctx context.Context, topic string) error {
+	form := url.Values{
+		"hub.mode":  {"publish"},
+		"hub.url":   {topic},
+		"hub.topic": {topic},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", fetch.UserAgent())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("hub responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
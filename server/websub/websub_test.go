@@ -0,0 +1,156 @@
+package websub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/events"
+	"github.com/indieinfra/scribble/server/util"
+)
+
+type ping struct {
+	contentType string
+	form        url.Values
+}
+
+// newHub starts a hub answering each ping with the next of statuses, repeating the last one, and
+// reporting every ping it receives.
+func newHub(t *testing.T, statuses ...int) (*httptest.Server, chan ping) {
+	t.Helper()
+
+	pings := make(chan ping, 32)
+	n := 0
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("hub received %s", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("hub could not parse ping: %v", err)
+		}
+		pings <- ping{contentType: r.Header.Get("Content-Type"), form: r.PostForm}
+
+		w.WriteHeader(statuses[min(n, len(statuses)-1)])
+		n++
+	}))
+	t.Cleanup(hub.Close)
+
+	return hub, pings
+}
+
+func newTestPublisher(t *testing.T, cfg *config.WebSub) *Publisher {
+	t.Helper()
+
+	p := NewPublisher("https://example.com/", cfg)
+	p.backoff = time.Millisecond
+	t.Cleanup(func() { p.Close(context.Background()) })
+
+	return p
+}
+
+func receive(t *testing.T, pings chan ping, n int) []ping {
+	t.Helper()
+
+	var got []ping
+	for range n {
+		select {
+		case p := <-pings:
+			got = append(got, p)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d pings, want %d", len(got), n)
+		}
+	}
+
+	return got
+}
+
+func expectNoMore(t *testing.T, pings chan ping) {
+	t.Helper()
+
+	select {
+	case p := <-pings:
+		t.Errorf("unexpected ping %v", p.form)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPublishSendsPublishForm(t *testing.T) {
+	hub, pings := newHub(t, http.StatusNoContent)
+	p := newTestPublisher(t, &config.WebSub{Hub: hub.URL, Topics: []string{"https://example.com/feed.xml", "https://example.com/"}})
+
+	p.Handle(context.Background(), events.Event{Type: events.PostCreated, Url: "https://example.com/posts/1"})
+
+	got := receive(t, pings, 2)
+	for i, topic := range []string{"https://example.com/feed.xml", "https://example.com/"} {
+		if got[i].contentType != "application/x-www-form-urlencoded" {
+			t.Errorf("Content-Type = %q", got[i].contentType)
+		}
+		want := url.Values{"hub.mode": {"publish"}, "hub.url": {topic}, "hub.topic": {topic}}
+		if got[i].form.Encode() != want.Encode() {
+			t.Errorf("ping %d = %v, want %v", i, got[i].form, want)
+		}
+	}
+	expectNoMore(t, pings)
+}
+
+func TestPublishDefaultsToHomePage(t *testing.T) {
+	hub, pings := newHub(t, http.StatusAccepted)
+	p := newTestPublisher(t, &config.WebSub{Hub: hub.URL})
+
+	p.Handle(context.Background(), events.Event{Type: events.PostUpdated})
+
+	if got := receive(t, pings, 1); got[0].form.Get("hub.topic") != "https://example.com/" {
+		t.Errorf("topic = %q", got[0].form.Get("hub.topic"))
+	}
+}
+
+func TestPublishRetriesFailedPings(t *testing.T) {
+	hub, pings := newHub(t, http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusNoContent)
+	p := newTestPublisher(t, &config.WebSub{Hub: hub.URL})
+
+	p.Handle(context.Background(), events.Event{Type: events.PostDeleted})
+
+	for _, got := range receive(t, pings, 3) {
+		if got.form.Get("hub.mode") != "publish" {
+			t.Errorf("retried ping = %v", got.form)
+		}
+	}
+	expectNoMore(t, pings)
+}
+
+func TestPublishGivesUpAfterMaxAttempts(t *testing.T) {
+	hub, pings := newHub(t, http.StatusBadGateway)
+	p := newTestPublisher(t, &config.WebSub{Hub: hub.URL, MaxAttempts: 3})
+
+	p.Handle(context.Background(), events.Event{Type: events.PostCreated})
+
+	receive(t, pings, 3)
+	expectNoMore(t, pings)
+}
+
+func TestPublishIgnoresMediaEvents(t *testing.T) {
+	hub, pings := newHub(t, http.StatusNoContent)
+	p := newTestPublisher(t, &config.WebSub{Hub: hub.URL})
+
+	p.Handle(context.Background(), events.Event{Type: events.MediaUploaded})
+	p.Handle(context.Background(), events.Event{Type: events.MediaDeleted})
+
+	expectNoMore(t, pings)
+}
+
+func TestPublishSkipsDrafts(t *testing.T) {
+	hub, pings := newHub(t, http.StatusNoContent)
+	p := newTestPublisher(t, &config.WebSub{Hub: hub.URL})
+
+	doc := &util.Mf2Document{Properties: util.MicroformatProperties{"post-status": {"draft"}}}
+	p.Handle(context.Background(), events.Event{Type: events.PostCreated, Document: doc})
+	expectNoMore(t, pings)
+
+	doc = &util.Mf2Document{Properties: util.MicroformatProperties{"post-status": {"published"}}}
+	p.Handle(context.Background(), events.Event{Type: events.PostUpdated, Document: doc})
+	receive(t, pings, 1)
+}
@@ -39,3 +39,9 @@ type Store interface {
 	// traversing the git tree, a non-nil error will be returned
 	ExistsBySlug(ctx context.Context, slug string) (bool, error)
 }
+
+// HealthChecker may be implemented by a Store to report whether its backend is currently reachable.
+type HealthChecker interface {
+	// HealthCheck returns a non-nil error if the store cannot currently serve requests.
+	HealthCheck(ctx context.Context) error
+}
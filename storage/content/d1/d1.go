@@ -4,7 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"slices"
 	"strings"
 
@@ -12,6 +13,7 @@ import (
 	cfd1 "github.com/cloudflare/cloudflare-go/v6/d1"
 	"github.com/cloudflare/cloudflare-go/v6/option"
 	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/tracing"
 	"github.com/indieinfra/scribble/server/util"
 	"github.com/indieinfra/scribble/storage/content"
 	storageutil "github.com/indieinfra/scribble/storage/util"
@@ -57,7 +59,10 @@ func NewD1ContentStore(cfg *config.Content) (*StoreImpl, error) {
 // buildD1Client creates a Cloudflare client configured with API token and optional custom endpoint.
 // The httpClient parameter is used for testing; pass nil for production use.
 func buildD1Client(cfg *config.D1ContentStrategy) *cloudflare.Client {
-	opts := []option.RequestOption{option.WithAPIToken(strings.TrimSpace(cfg.APIToken))}
+	opts := []option.RequestOption{
+		option.WithAPIToken(strings.TrimSpace(cfg.APIToken)),
+		option.WithHTTPClient(&http.Client{Transport: tracing.Transport(nil)}),
+	}
 
 	if base := strings.TrimSpace(cfg.Endpoint); base != "" {
 		opts = append(opts, option.WithBaseURL(strings.TrimSuffix(base, "/")))
@@ -302,22 +307,29 @@ func (cs *StoreImpl) List(ctx context.Context, page int, limit int) ([]util.Mf2D
 	}
 
 	docs := make([]util.Mf2Document, 0, len(rows))
+	skipped := 0
 	for _, row := range rows {
 		raw, ok := row["doc"].(string)
 		if !ok || raw == "" {
-			log.Println("warning: no document found in row")
+			slog.WarnContext(ctx, "no document found in row")
+			skipped++
 			continue
 		}
 
 		var doc util.Mf2Document
 		if err := json.Unmarshal([]byte(raw), &doc); err != nil {
-			log.Println("warning: failed to unmarshal document json:", err)
+			slog.WarnContext(ctx, "failed to unmarshal document json", "error", err)
+			skipped++
 			continue
 		}
 
 		docs = append(docs, doc)
 	}
 
+	if skipped > 0 {
+		return docs, fmt.Errorf("%w: skipped %d of %d rows", content.ErrUnreadable, skipped, len(rows))
+	}
+
 	return docs, nil
 }
 
@@ -385,6 +397,12 @@ func (cs *StoreImpl) ExistsBySlug(ctx context.Context, slug string) (bool, error
 	return len(rows) > 0, nil
 }
 
+// HealthCheck runs a trivial query to confirm the D1 API is reachable and the credentials are valid.
+func (cs *StoreImpl) HealthCheck(ctx context.Context) error {
+	_, err := cs.executeQuery(ctx, "SELECT 1")
+	return err
+}
+
 // executeQuery sends a SQL query to the D1 database and returns the result rows.
 // Returns nil rows (no error) when the query succeeds but produces no results.
 func (cs *StoreImpl) executeQuery(ctx context.Context, sql string, params ...any) ([]map[string]any, error) {
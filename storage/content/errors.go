@@ -4,3 +4,7 @@ import "errors"
 
 // ErrNotFound indicates that a content document was not found.
 var ErrNotFound = errors.New("content not found")
+
+// ErrUnreadable indicates that a listing skipped documents the store could not read. The documents
+// that could be read are returned alongside it.
+var ErrUnreadable = errors.New("content documents could not be read")
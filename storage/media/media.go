@@ -1,11 +1,53 @@
 package media
 
 import (
+	"bytes"
 	"context"
-	"mime/multipart"
+	"errors"
+	"io"
+	"time"
 )
 
+// ErrNotFound indicates that no object exists under a key.
+var ErrNotFound = errors.New("media not found")
+
 type Store interface {
-	Upload(ctx context.Context, file *multipart.File, header *multipart.FileHeader, key string) (string, error)
+	// Upload stores the contents of r under key and returns its public URL. size is the number of
+	// bytes r will yield, or -1 when it is not known in advance, as with a file streamed from a client.
+	Upload(ctx context.Context, r io.Reader, size int64, contentType string, key string) (string, error)
 	Delete(ctx context.Context, url string) error
+	// Stat describes the object stored under key, or returns ErrNotFound if there is none.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns stored objects in key order, up to opts.Limit of them.
+	List(ctx context.Context, opts ListOptions) ([]ObjectInfo, error)
+}
+
+// ListOptions selects the objects returned by Store.List.
+type ListOptions struct {
+	// Prefix restricts the listing to keys starting with it.
+	Prefix string
+	// After resumes a listing after the given key.
+	After string
+	Limit int
+}
+
+// ObjectInfo describes a stored media object.
+type ObjectInfo struct {
+	Key          string
+	Url          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// HealthChecker may be implemented by a Store to report whether its backend is currently reachable.
+type HealthChecker interface {
+	// HealthCheck returns a non-nil error if the store cannot currently serve requests.
+	HealthCheck(ctx context.Context) error
+}
+
+// UploadBytes uploads an in-memory file, for media Scribble produces or fetches itself rather than
+// receiving from a client.
+func UploadBytes(ctx context.Context, store Store, data []byte, contentType string, key string) (string, error) {
+	return store.Upload(ctx, bytes.NewReader(data), int64(len(data)), contentType, key)
 }
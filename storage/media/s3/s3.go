@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -13,14 +13,22 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 
 	"github.com/indieinfra/scribble/config"
+	"github.com/indieinfra/scribble/server/tracing"
+	"github.com/indieinfra/scribble/storage/media"
 	"github.com/indieinfra/scribble/storage/util"
 )
 
+// streamPartSize is the part size for uploads of unknown length, which bounds the memory each one
+// takes. S3 allows at most 10,000 parts, so this also caps such uploads at about 156 GiB.
+const streamPartSize = 16 << 20
+
 // StoreImpl uploads media to S3 or any compatible service (R2, Backblaze, MinIO).
 type s3Client interface {
 	BucketExists(ctx context.Context, bucketName string) (bool, error)
 	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
 	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
 }
 
 var newMinioClient = func(endpoint string, opts *minio.Options) (s3Client, error) {
@@ -64,11 +72,17 @@ func NewS3MediaStore(cfg *config.Media) (*StoreImpl, error) {
 
 	lookup := minio.BucketLookupAuto
 
+	transport, err := minio.DefaultTransport(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 transport: %w", err)
+	}
+
 	client, err := newMinioClient(endpointHost, &minio.Options{
 		Creds:        credentials.NewStaticV4(s3cfg.AccessKeyId, s3cfg.SecretKeyId, ""),
 		Secure:       true,
 		Region:       region,
 		BucketLookup: lookup,
+		Transport:    tracing.Transport(transport),
 	})
 
 	if err != nil {
@@ -96,14 +110,19 @@ func NewS3MediaStore(cfg *config.Media) (*StoreImpl, error) {
 	}, nil
 }
 
-func (s *StoreImpl) Upload(ctx context.Context, file *multipart.File, header *multipart.FileHeader, key string) (string, error) {
-	if file == nil || header == nil {
-		return "", fmt.Errorf("file and header are required")
+func (s *StoreImpl) Upload(ctx context.Context, r io.Reader, size int64, contentType string, key string) (string, error) {
+	if r == nil {
+		return "", fmt.Errorf("file is required")
 	}
 
-	opts := minio.PutObjectOptions{ContentType: header.Header.Get("Content-Type")}
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if size < 0 {
+		// Without a length the client uploads in parts, buffering one part at a time; keep that small
+		// rather than sized for the largest possible object.
+		opts.PartSize = streamPartSize
+	}
 
-	if _, err := s.client.PutObject(ctx, s.bucket, key, *file, header.Size, opts); err != nil {
+	if _, err := s.client.PutObject(ctx, s.bucket, key, r, size, opts); err != nil {
 		return "", fmt.Errorf("upload to s3 failed: %w", err)
 	}
 
@@ -123,6 +142,69 @@ func (s *StoreImpl) Delete(ctx context.Context, urlStr string) error {
 	return nil
 }
 
+func (s *StoreImpl) Stat(ctx context.Context, key string) (*media.ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if resp := minio.ToErrorResponse(err); resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey" {
+			return nil, media.ErrNotFound
+		}
+		return nil, fmt.Errorf("stat in s3 failed: %w", err)
+	}
+
+	return &media.ObjectInfo{
+		Key:          key,
+		Url:          s.objectURL(key),
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *StoreImpl) List(ctx context.Context, opts media.ListOptions) ([]media.ObjectInfo, error) {
+	// Stop the listing goroutine once enough objects have been read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var objects []media.ObjectInfo
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:     opts.Prefix,
+		StartAfter: opts.After,
+		Recursive:  true,
+	}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("list in s3 failed: %w", info.Err)
+		}
+
+		objects = append(objects, media.ObjectInfo{
+			Key:          info.Key,
+			Url:          s.objectURL(info.Key),
+			Size:         info.Size,
+			ContentType:  info.ContentType,
+			LastModified: info.LastModified,
+		})
+
+		if opts.Limit > 0 && len(objects) == opts.Limit {
+			break
+		}
+	}
+
+	return objects, nil
+}
+
+// HealthCheck confirms the bucket is still reachable with the configured credentials.
+func (s *StoreImpl) HealthCheck(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("failed to reach s3 bucket %q: %w", s.bucket, err)
+	}
+
+	if !exists {
+		return fmt.Errorf("s3 bucket %q does not exist or is not accessible", s.bucket)
+	}
+
+	return nil
+}
+
 func (s *StoreImpl) objectURL(key string) string {
 	return fmt.Sprintf("%s%s", s.publicBase, key)
 }
@@ -13,6 +13,8 @@ import (
 //   - {month}   - 2-digit month (e.g., "01")
 //   - {day}     - 2-digit day (e.g., "15")
 //   - {slug}    - the document slug
+//   - {hash}    - the hex SHA-256 of a media file's contents (media only)
+//   - {ext}     - a media file's extension including the dot, e.g. ".jpg", or nothing if unknown (media only)
 type PathPattern struct {
 	pattern string
 }
@@ -42,6 +44,37 @@ func (p *PathPattern) Generate(slug string) (string, error) {
 	return filepath.Clean(result), nil
 }
 
+// GenerateFile produces a media path like Generate, additionally replacing {hash} with the given
+// content hash and {ext} with the given extension.
+func (p *PathPattern) GenerateFile(slug string, hash string, ext string) (string, error) {
+	if p.UsesHash() && hash == "" {
+		return "", fmt.Errorf("hash cannot be empty")
+	}
+
+	result, err := NewPathPattern(strings.ReplaceAll(p.pattern, "{hash}", hash)).Generate(slug)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ReplaceAll(result, "{ext}", ext), nil
+}
+
+// Prefix returns the fixed leading directories of the pattern, before any placeholder, e.g. "media/"
+// for "media/{year}/{slug}". Every generated path starts with it.
+func (p *PathPattern) Prefix() string {
+	fixed, _, _ := strings.Cut(p.pattern, "{")
+	if i := strings.LastIndex(fixed, "/"); i >= 0 {
+		return fixed[:i+1]
+	}
+
+	return ""
+}
+
+// UsesHash reports whether the pattern contains {hash}, making the generated paths content-addressed.
+func (p *PathPattern) UsesHash() bool {
+	return strings.Contains(p.pattern, "{hash}")
+}
+
 // DefaultContentPattern returns the default pattern for content files.
 // Pattern: "{slug}.json" (flat structure in content directory)
 func DefaultContentPattern() *PathPattern {